@@ -0,0 +1,247 @@
+package main
+
+import (
+  "crypto/subtle"
+  "encoding/json"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "net/http"
+  "regexp"
+  "strconv"
+  "strings"
+  "time"
+  . "github.com/ErintLabs/trellohub/genapi"
+  "github.com/ErintLabs/trellohub/trello"
+)
+
+/* Admin endpoints need $ADMIN_TOKEN, either as a bearer token or as ?token= */
+func adminAuthorised(r *http.Request) bool {
+  if len(config.AdminToken) == 0 {
+    return false
+  }
+
+  token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+  if len(token) == 0 {
+    token = r.URL.Query().Get("token")
+  }
+  return subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminToken)) == 1
+}
+
+type adminSubroutine func (r *http.Request, body []byte) (int, interface{})
+
+/* Same as GeneralisedProcess, but authenticated and replying with JSON */
+func AdminProcess(w http.ResponseWriter, r *http.Request, f adminSubroutine) {
+  if !adminAuthorised(r) {
+    http.Error(w, "Who are you?", http.StatusUnauthorized)
+    return
+  }
+
+  body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1 << 20))
+  if err != nil {
+    http.Error(w, err.Error(), http.StatusBadRequest)
+    return
+  }
+
+  lockCache()
+  code, data := f(r, body)
+  cache.mutex.Unlock()
+
+  w.Header().Set("Content-Type", "application/json")
+  w.WriteHeader(code)
+  json.NewEncoder(w).Encode(data)
+}
+
+/* Shortcut for error replies */
+func adminError(code int, text string) (int, interface{}) {
+  return code, map[string]string{ "error": text }
+}
+
+/* What happened recently, newest last */
+type eventRecord struct {
+  Time    time.Time `json:"time"`
+  Id      string    `json:"id,omitempty"`
+  Path    string    `json:"path"`
+  Event   string    `json:"event"`
+  Code    int       `json:"code"`
+  Result  string    `json:"result"`
+}
+
+const recentEventsLimit = 100
+var recentEvents []eventRecord
+
+/* Figures out which event the hook delivered and what kind it is: GitHub tells it in headers, Trello in the body */
+func describeEvent(r *http.Request, body []byte) (id string, evt string) {
+  if evt := r.Header.Get("X-GitHub-Event"); len(evt) > 0 {
+    var payload struct {
+      Action string `json:"action"`
+    }
+    json.Unmarshal(body, &payload)
+    if len(payload.Action) > 0 {
+      evt += "." + payload.Action
+    }
+    return r.Header.Get("X-GitHub-Delivery"), evt
+  }
+
+  var payload struct {
+    Action struct {
+      Id   string `json:"id"`
+      Type string `json:"type"`
+    }             `json:"action"`
+  }
+  json.Unmarshal(body, &payload)
+  return payload.Action.Id, payload.Action.Type
+}
+
+/* Called under the mutex by GeneralisedProcess */
+func recordEvent(r *http.Request, id string, evt string, code int, result string) {
+  recentEvents = append(recentEvents, eventRecord{ time.Now(), id, r.URL.Path, evt, code, result })
+  if len(recentEvents) > recentEventsLimit {
+    recentEvents = recentEvents[len(recentEvents) - recentEventsLimit:]
+  }
+}
+
+type hookStatus struct {
+  Target  string  `json:"target"`
+  URL     string  `json:"url"`
+  Events  []string `json:"events,omitempty"`
+  Healthy bool    `json:"healthy"`
+  Detail  string  `json:"detail,omitempty"`
+}
+
+/* Our hooks on the board and on every repository with their health as reported by the services */
+func hookStatuses() []hookStatus {
+  res := make([]hookStatus, 0)
+  hooks, err := trello_obj.Hooks()
+  if err != nil {
+    res = append(res, hookStatus{ Target: config.BoardId, Detail: err.Error() })
+  }
+  for _, v := range hooks {
+    if !strings.HasPrefix(v.URL, config.BaseURL) {
+      continue
+    }
+    st := hookStatus{ Target: v.Model, URL: v.URL, Healthy: v.Active && v.Failures == 0 }
+    if v.Failures > 0 {
+      st.Detail = fmt.Sprintf("%d consecutive failures since %s", v.Failures, v.FailedAt)
+    } else if !v.Active {
+      st.Detail = "inactive"
+    }
+    res = append(res, st)
+  }
+
+  for _, repo := range trello_obj.Repos() {
+    hooks, err := github_obj.Hooks(repo)
+    if err != nil {
+      res = append(res, hookStatus{ Target: repo, Detail: err.Error() })
+    }
+    for _, v := range hooks {
+      if !strings.HasPrefix(v.Config.URL, config.BaseURL) {
+        continue
+      }
+      st := hookStatus{ Target: repo, URL: v.Config.URL, Events: v.Events, Healthy: v.Active }
+      if v.LastResponse != nil && v.LastResponse.Code != 0 {
+        st.Healthy = st.Healthy && v.LastResponse.Code < 300
+        st.Detail = fmt.Sprintf("%d %s", v.LastResponse.Code, v.LastResponse.Message)
+      } else if !v.Active {
+        st.Detail = "inactive"
+      }
+      res = append(res, st)
+    }
+  }
+  return res
+}
+
+/* The read-only views, each a function so that they're evaluated under the mutex */
+var adminViews = map[string]func () interface{} {
+  "repos": func () interface{} { return trello_obj.RepoLabels() },
+  "links": func () interface{} { return trello_obj.Links() },
+  "lists": func () interface{} { return trello_obj.Lists },
+  /* /admin/users itself is the user table, so this one is only seen in /admin */
+  "users": func () interface{} {
+    return struct {
+      Members   map[string]string `json:"members"`
+      Users     map[string]string `json:"users"`
+    }{ trello_obj.MemberIds(), cache.GitHubUserByTrello }
+  },
+  "hooks": func () interface{} { return hookStatuses() },
+  "events": func () interface{} { return recentEvents },
+  "sync": func () interface{} { return cache.SyncReports },
+}
+
+func AdminFunc(w http.ResponseWriter, r *http.Request) {
+  AdminProcess(w, r, func (r *http.Request, body []byte) (int, interface{}) {
+    view := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin"), "/")
+    if len(view) == 0 {
+      /* Everything but the hooks, which take a few requests */
+      res := make(map[string]interface{})
+      for k, f := range adminViews {
+        if k != "hooks" {
+          res[k] = f()
+        }
+      }
+      return http.StatusOK, res
+    }
+
+    if f := adminViews[view]; f != nil && r.Method == "GET" {
+      return http.StatusOK, f()
+    }
+    return adminError(http.StatusNotFound, "No such view.")
+  })
+}
+
+/* POST ?card=<id or short link> or ?issue=owner/repo#N re-reads it from the server */
+func AdminRefreshFunc(w http.ResponseWriter, r *http.Request) {
+  AdminProcess(w, r, func (r *http.Request, body []byte) (int, interface{}) {
+    if r.Method != "POST" {
+      return adminError(http.StatusMethodNotAllowed, "Use POST.")
+    }
+
+    /* Renames only show up in the member list */
+    if err := trello_obj.RefreshMembers(); err != nil {
+      Warnf("Can't refresh the board members: %s", err)
+    }
+
+    query := r.URL.Query()
+    if cardid := query.Get("card"); len(cardid) > 0 {
+      card := trello_obj.FindCardByLink(cardid)
+      if card == nil {
+        card = trello_obj.GetCard(cardid)
+      }
+      card.Reload()
+      return http.StatusOK, card
+    }
+
+    if spec := query.Get("issue"); len(spec) > 0 {
+      res := regexp.MustCompile("^" + REGEX_GH_OWNREPO + "#([0-9]+)$").FindStringSubmatch(spec)
+      if res == nil {
+        return adminError(http.StatusBadRequest, "Expected owner/repo#N.")
+      }
+      issueno, _ := strconv.Atoi(res[len(res) - 1])
+      issue := github_obj.GetIssue(res[1], issueno)
+      issue.Reload()
+      return http.StatusOK, issue
+    }
+
+    return http.StatusOK, trello_obj.MemberIds()
+  })
+}
+
+/* GET checks the workflow labels in every served repository, POST fixes what's wrong */
+func AdminLabelsFunc(w http.ResponseWriter, r *http.Request) {
+  AdminProcess(w, r, func (r *http.Request, body []byte) (int, interface{}) {
+    if r.Method != "GET" && r.Method != "POST" {
+      return adminError(http.StatusMethodNotAllowed, "Use GET to check or POST to fix.")
+    }
+
+    res := make(map[string]interface{})
+    for _, repo := range trello_obj.Repos() {
+      checks, err := github_obj.EnsureLabels(repo, trello.WorkflowLabels(), r.Method == "POST")
+      if err != nil {
+        res[repo] = map[string]interface{}{ "error": err.Error(), "labels": checks }
+      } else {
+        res[repo] = checks
+      }
+    }
+    return http.StatusOK, res
+  })
+}
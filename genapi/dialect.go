@@ -0,0 +1,101 @@
+/* Conversion between GitHub and Trello flavours of Markdown.
+   Every rewrite on one side is undone on the other or stays put, so that syncing back and forth
+   doesn't grow the text. Tables and footnotes are readable as plain text and are left alone. */
+package genapi
+
+import (
+  "regexp"
+  "strings"
+)
+
+const REGEX_MD_LINK string = "\\[[^\\]]*\\]\\([^)]*\\)"
+/* Bare #12 or owner/repo#12 not glued to a word, a link or an HTML entity */
+const REGEX_MD_ISSUEREF string = "(^|[^[:alnum:]_/\\[#&])(" + REGEX_GH_OWNREPO + "?#([0-9]+))\\b"
+/* Same as a Trello link back to the issue */
+const REGEX_MD_ISSUELINK string = "\\[((?i)(?:[a-z0-9-.]+/[a-z0-9-.]+)?#([0-9]+))\\]\\(https://github\\.com/([^/[:space:]]+/[^/[:space:]]+)/issues/([0-9]+)\\)"
+const REGEX_MD_ALERT string = "(?m)^(>[ \\t]*)\\[!(NOTE|TIP|IMPORTANT|WARNING|CAUTION)\\][ \\t]*(\\r?)$"
+const REGEX_MD_ALERT_TRELLO string = "(?m)^(>[ \\t]*)\\*\\*(Note|Tip|Important|Warning|Caution)\\*\\*[ \\t]*(\\r?)$"
+/* Collapsible sections, the summary is optional */
+const REGEX_MD_DETAILS string = "(?is)<details([^>]*)>([ \\t\\r\\n]*)(?:<summary>(.*?)</summary>)?"
+const REGEX_MD_DETAILS_END string = "(?i)</details>"
+/* Their Trello markers: ▸ or ▾ (open) before the bold summary, ◂ at the end */
+const REGEX_MD_DETAILS_TRELLO string = "\\*\\*([▸▾])\\*\\*([ \\t\\r\\n]*)\\*\\*(.*?)\\*\\*"
+const REGEX_MD_DETAILS_END_TRELLO string = "\\*\\*◂\\*\\*"
+const REGEX_MD_BREAK string = "(?i)<br[ \\t]*/?>"
+
+/* Turns GitHub issue text into a Trello description of the repository */
+func GitHubToTrello(text string, repoid string) string {
+  return MapProse(text, func (prose string) string {
+    /* Issue references become links, unless they are in one already */
+    prose = MapOutside(prose, REGEX_MD_LINK + "|" + REGEX_MD_URL, func (part string) string {
+      return subAll(part, REGEX_MD_ISSUEREF, func (v []string) string {
+        repo := v[3]
+        if len(repo) == 0 {
+          repo = repoid
+        }
+        return v[1] + "[" + v[2] + "](https://github.com/" + repo + "/issues/" + v[4] + ")"
+      })
+    })
+
+    /* Alerts degrade to a bold title of the quote */
+    prose = subAll(prose, REGEX_MD_ALERT, func (v []string) string {
+      return v[1] + "**" + v[2][:1] + strings.ToLower(v[2][1:]) + "**" + v[3]
+    })
+
+    /* Collapsible sections are expanded, but marked so that they come back on GitHub; HTML breaks become real ones */
+    prose = subAll(prose, REGEX_MD_DETAILS, func (v []string) string {
+      marker, summary := "▸", strings.TrimSpace(v[3])
+      if regexp.MustCompile("(?i)\\bopen\\b").MatchString(v[1]) {
+        marker = "▾"
+      }
+      /* Markers glued to the summary don't read as bold */
+      if len(summary) == 0 {
+        return "**" + marker + "** **Details**" + v[2] // what GitHub shows anyway
+      }
+      if len(v[2]) == 0 {
+        v[2] = " "
+      }
+      return "**" + marker + "**" + v[2] + "**" + summary + "**"
+    })
+    prose = subAll(prose, REGEX_MD_DETAILS_END, func (v []string) string {
+      return "**◂**"
+    })
+    return subAll(prose, REGEX_MD_BREAK, func (v []string) string {
+      return "\n"
+    })
+  })
+}
+
+/* And back */
+func TrelloToGitHub(text string, repoid string) string {
+  return MapProse(text, func (prose string) string {
+    /* Links to issues are turned back into references if they look exactly like ours */
+    prose = subAll(prose, REGEX_MD_ISSUELINK, func (v []string) string {
+      ref, number, repo := v[1], v[2], v[3]
+      if number == v[4] {
+        if i := strings.Index(ref, "#"); (i == 0 && strings.EqualFold(repo, repoid)) || strings.EqualFold(ref[:i], repo) {
+          return ref
+        }
+      }
+      return v[0]
+    })
+
+    prose = subAll(prose, REGEX_MD_DETAILS_TRELLO, func (v []string) string {
+      open := ""
+      if v[1] == "▾" {
+        open = " open"
+      }
+      if v[2] == " " {
+        v[2] = ""
+      }
+      return "<details" + open + ">" + v[2] + "<summary>" + v[3] + "</summary>"
+    })
+    prose = subAll(prose, REGEX_MD_DETAILS_END_TRELLO, func (v []string) string {
+      return "</details>"
+    })
+
+    return subAll(prose, REGEX_MD_ALERT_TRELLO, func (v []string) string {
+      return v[1] + "[!" + strings.ToUpper(v[2]) + "]" + v[3]
+    })
+  })
+}
@@ -0,0 +1,54 @@
+package genapi
+
+import (
+  "testing"
+)
+
+func TestDialect(t *testing.T) {
+  cases := []struct {
+    github  string
+    trello  string
+  }{
+    { "Fixes #12", "Fixes [#12](https://github.com/owner/repo/issues/12)" },
+    { "See other/lib#3.", "See [other/lib#3](https://github.com/other/lib/issues/3)." },
+    { "Already [#12](https://github.com/owner/repo/issues/12)", "Already [#12](https://github.com/owner/repo/issues/12)" },
+    { "Not an issue: a#1, `#12`", "Not an issue: a#1, `#12`" },
+    { "> [!WARNING]\n> Careful", "> **Warning**\n> Careful" },
+    { "Intro\n<details>\n<summary>Logs</summary>\n\nsome\n</details>\nend", "Intro\n**▸**\n**Logs**\n\nsome\n**◂**\nend" },
+    { "<details open><summary>A b</summary>x</details>", "**▾** **A b**x**◂**" },
+    { "<details>\nplain\n</details>", "**▸** **Details**\nplain\n**◂**" },
+  }
+
+  for _, c := range cases {
+    trello := GitHubToTrello(c.github, "owner/repo")
+    if trello != c.trello {
+      t.Errorf("%q to Trello: got %q, want %q", c.github, trello, c.trello)
+    }
+    /* Once converted, it doesn't change going back and forth */
+    back := TrelloToGitHub(trello, "owner/repo")
+    if again := GitHubToTrello(back, "owner/repo"); again != trello {
+      t.Errorf("%q: round trip through %q gave %q", c.github, back, again)
+    }
+  }
+}
+
+func TestDialectBack(t *testing.T) {
+  cases := []struct {
+    trello  string
+    github  string
+  }{
+    { "[#12](https://github.com/owner/repo/issues/12)", "#12" },
+    { "[other/lib#3](https://github.com/other/lib/issues/3)", "other/lib#3" },
+    /* Someone else's links stay as they are */
+    { "[#12](https://github.com/other/lib/issues/12)", "[#12](https://github.com/other/lib/issues/12)" },
+    { "[#12](https://github.com/owner/repo/issues/13)", "[#12](https://github.com/owner/repo/issues/13)" },
+    { "> **Note**", "> [!NOTE]" },
+    { "**▾** **A b**x**◂**", "<details open><summary>A b</summary>x</details>" },
+  }
+
+  for _, c := range cases {
+    if res := TrelloToGitHub(c.trello, "owner/repo"); res != c.github {
+      t.Errorf("%q to GitHub: got %q, want %q", c.trello, res, c.github)
+    }
+  }
+}
@@ -9,19 +9,35 @@ import (
   "bytes"
   "strings"
   "regexp"
-  "log"
+  "strconv"
+  "time"
 )
 
 const REGEX_GH_OWNREPO string = "(?i)([a-z0-9][a-z0-9-.]{0,38}[a-z0-9]/[a-z0-9][a-z0-9-.]{0,38}[a-z0-9])"
-const REGEX_GH_REPO string = "^(?:https?://)?github.com/" + REGEX_GH_OWNREPO
+const REGEX_GH_REPO string = "(?i)^(?:https?://)?github\\.com/" + REGEX_GH_OWNREPO
 const REGEX_GH_ISSUE string = REGEX_GH_REPO + "/issues/([0-9]*)"
 const REGEX_GH_BRANCH string = "(?i)^refs/heads/(.*)$"
+const REGEX_GH_TAG string = "(?i)^refs/tags/(.*)$"
+/* Screenshots and files uploaded to GitHub */
+const REGEX_GH_UPLOAD string = "(?i)https://(?:github\\.com/user-attachments/|github\\.com/[a-z0-9-.]+/[a-z0-9-.]+/(?:files|assets)/|(?:private-)?user-images\\.githubusercontent\\.com/)[^)[:space:]\"'<>]+"
+/* Anything hosted by GitHub, as opposed to Trello uploads */
+const REGEX_GH_HOSTED string = "(?i)^https?://(?:[a-z0-9-]+\\.)*(?:github\\.com|githubusercontent\\.com)/"
+/* Item of the attachment section we keep in issue bodies */
+const REGEX_GH_ATTACHMENT string = "(?m)^- \\[([^\\]]*)\\]\\(([^)[:space:]]+)\\)"
+/* Trello cards referenced from commits and PRs: trello.com/c/AbCd1234 or [trello:AbCd1234] */
+const REGEX_TRELLO_CARD string = "(?i)(?:trello\\.com/c/|\\[trello:)([a-z0-9]{8})"
+/* Standard message of git revert, first group is the reverted commit */
+const REGEX_GH_REVERT string = "This reverts commit ([0-9a-fA-F]{7,40})"
+/* Default pattern for feature branches, the first group that matched is the issue number: 123-fix-login, issue-123, feature/GH-123.
+   A bare number needs a word after it, so that 2024-q1-cleanup or 2024-01-release are not taken for issues */
+const REGEX_GH_FEATURE string = "(?i)(?:^|/)(?:(?:gh|issue)-([0-9]+)(?:[-_/]|$)|([0-9]+)-[a-z][a-z]+(?:[-_/]|$))"
 // TODO: this ignores nesting, only top level is processed
 // TODO: this might not work well with backslashes
 const REGEX_GH_CHECK string = "(?:^|\\r\\n)- \\[([ xX])\\] ([^\\r]*)"
 // TODO: possibly separate GH and Trello version
 const REGEX_GH_USER string = "(?i)@([a-z0-9][a-z0-9-]{0,38}[a-z0-9])"
-const REGEX_GH_MAGIC string = "(?i)(?:close|closes|closed|fix|fixes|fixed|resolve|resolves|resolved)[[:space:]]*" + REGEX_GH_OWNREPO + "?#([0-9]*)"
+/* Issue references: full URLs, owner/repo#12, #12 and GH-12, to be preceded by keywords */
+const REGEX_GH_ISSUEREF string = "((?:https?://)?github\\.com/[a-z0-9-.]+/[a-z0-9-.]+/issues/[0-9]+|" + REGEX_GH_OWNREPO + "?#[0-9]+|gh-[0-9]+)"
 
 type Set map[string]bool
 
@@ -75,7 +91,10 @@ func StrSub(source string, regtxt string, f StrSub_c) string {
   	lo := catch[1] - catch[0]
     par := make([]string, lc)
     for i := 0; i < lc; i++ {
-      par[i] = res[catch[i*2] + j:catch[i*2+1] + j]
+      /* Optional groups might not participate */
+      if catch[i*2] >= 0 {
+        par[i] = res[catch[i*2] + j:catch[i*2+1] + j]
+      }
     }
     rep := f(par)
     res = res[:catch[0] + j] + rep + res[catch[1] + j:]
@@ -85,11 +104,10 @@ func StrSub(source string, regtxt string, f StrSub_c) string {
 }
 
 /* Replaces all occurences of @mentions between GitHub and Trello
-   second parameter determines the dictionary */
+   second parameter determines the dictionary, see TranslateMentions */
 func RepMentions(text string, dic map[string]string) string {
-  return StrSub(text, REGEX_GH_USER, func (v []string) string {
-    return "@"+dic[v[1]]
-  })
+  res, _ := TranslateMentions(text, dic)
+  return res
 }
 
 /* Generalised functions like JSON decoding or lower level http work */
@@ -108,77 +126,221 @@ func makeQuery(this GenAPI, rq string) string {
   return this.BaseURL() + rq + delim + this.AuthQuery()
 }
 
+/* Credentials can be part of the path too (Trello's /token/<token>/webhooks), they never make it to the logs or errors */
+func redact(this GenAPI, rq string) string {
+  values, _ := url.ParseQuery(this.AuthQuery())
+  for _, v := range values {
+    for _, secret := range v {
+      if len(secret) >= 8 { // real ones are way longer, short ones would mangle everything
+        rq = strings.Replace(rq, secret, "<redacted>", -1)
+      }
+    }
+  }
+  return rq
+}
+
+/* What went wrong with a request, the query is without the credentials */
+type APIError struct {
+  Method  string
+  Query   string
+  Status  int
+  Text    string
+}
+
+func (err *APIError) Error() string {
+  if err.Status == 0 {
+    return err.Method + " " + err.Query + ": " + err.Text
+  }
+  return err.Method + " " + err.Query + ": " + strconv.Itoa(err.Status) + " " + err.Text
+}
+
+/* Changes we tried to make since the last TakeCalls, so that failures can be reported and successes can clear them */
+type CallResult struct {
+  Method  string
+  Query   string
+  Err     error
+  Event   string  // what caused it, see SetCorrelation
+}
+
+var calls []CallResult
+
+/* Returns the changes made so far and starts over */
+func TakeCalls() []CallResult {
+  res := calls
+  calls = nil
+  return res
+}
+
+/* Only reading then, changes are logged instead of made */
+var DryRun bool
+
+func dryRun(method string, rq string) bool {
+  if DryRun {
+    Infof("Dry run, not making %s %s", method, rq)
+  }
+  return DryRun
+}
+
 /* HTTP method funcs basically all do the same, they compose the query and
    try to extract JSON output */
-func GenGET(this GenAPI, rq string, v interface{}) {
-  log.Printf("=> GET %s", rq)
+func GenGET(this GenAPI, rq string, v interface{}) error {
+  Infof("=> GET %s", redact(this, rq))
+  start := time.Now()
   resp, err := http.Get(makeQuery(this, rq))
-  processResponce(resp, err, &v)
+  observeCall(this, "GET", start, resp, err)
+  return processResponce("GET", redact(this, rq), "", resp, err, &v)
+}
+
+var apiCalls = NewCounter("trellohub_api_requests_total", "Requests made to Trello and GitHub.", "api", "method", "status")
+var apiLatency = NewHistogram("trellohub_api_request_duration_seconds", "Time taken by requests to Trello and GitHub.", DefaultBuckets, "api", "method")
+
+func observeCall(this GenAPI, method string, start time.Time, resp *http.Response, err error) {
+  api := this.BaseURL()
+  if u, perr := url.Parse(api); perr == nil && len(u.Host) > 0 {
+    api = u.Host
+  }
+
+  status := "error"
+  if err == nil {
+    status = strconv.Itoa(resp.StatusCode)
+  }
+  apiCalls.Inc(api, method, status)
+  apiLatency.Observe(time.Since(start).Seconds(), api, method)
 }
 
 /* Apparently no PUT or DELETE support in standard library, currently no output */
-func genericRequest(this GenAPI, method string, rq string, rdr io.Reader) {
-  log.Printf("=> %s %s", method, rq)
+func genericRequest(this GenAPI, method string, rq string, payload []byte) error {
+  Infof("=> %s %s", method, redact(this, rq))
+  if dryRun(method, redact(this, rq)) {
+    return nil
+  }
+  key := journalKey(method, redact(this, rq), payload)
+  if fromJournal(method, redact(this, rq), key, nil) {
+    return nil
+  }
+  var rdr io.Reader
+  if payload != nil {
+    rdr = bytes.NewReader(payload)
+  }
   client := &http.Client{}
   req, err := http.NewRequest(method, makeQuery(this, rq), rdr)
-  /* TODO error handling */
+  if err != nil {
+    return processResponce(method, redact(this, rq), key, nil, err, nil)
+  }
+  start := time.Now()
   resp, err := client.Do(req)
-  processResponce(resp, err, nil)
+  observeCall(this, method, start, resp, err)
+  return processResponce(method, redact(this, rq), key, resp, err, nil)
+}
+
+/* Answers the change from the journal if it was made before the interruption */
+func fromJournal(method string, rq string, key string, v interface{}) bool {
+  response, ok := replayed(key)
+  if !ok {
+    return false
+  }
+  Infof("Made before the interruption, not repeating %s %s", method, rq)
+  if v != nil && len(response) > 0 {
+    json.Unmarshal([]byte(response), &v)
+  }
+  calls = append(calls, CallResult{ method, rq, nil, Correlation() })
+  return true
 }
 
-func GenPUT(this GenAPI, rq string) {
-  genericRequest(this, "PUT", rq, nil)
+func GenPUT(this GenAPI, rq string) error {
+  return genericRequest(this, "PUT", rq, nil)
 }
 
-func GenDEL(this GenAPI, rq string) {
-  genericRequest(this, "DELETE", rq, nil)
+func GenDEL(this GenAPI, rq string) error {
+  return genericRequest(this, "DELETE", rq, nil)
 }
 
 /* Maybe generalise with other JSON func */
-func GenDELJSON(this GenAPI, rq string, v interface{}) {
+func GenDELJSON(this GenAPI, rq string, v interface{}) error {
   // TODO JSON errors
   payload, _ := json.Marshal(&v)
-  genericRequest(this, "DELETE", rq, bytes.NewReader(payload))
+  return genericRequest(this, "DELETE", rq, payload)
 }
 
-func GenPATCHJSON(this GenAPI, rq string, v interface{}) {
+func GenPATCHJSON(this GenAPI, rq string, v interface{}) error {
   // TODO JSON errors
   payload, _ := json.Marshal(&v)
-  genericRequest(this, "PATCH", rq, bytes.NewReader(payload))
+  return genericRequest(this, "PATCH", rq, payload)
 }
 
 /* Pass a map, process structure later */
-func GenPOSTForm(this GenAPI, rq string, v interface{}, f url.Values) { // TODO replace url.values with a struct
-  log.Printf("=> POST %s", rq)
+func GenPOSTForm(this GenAPI, rq string, v interface{}, f url.Values) error { // TODO replace url.values with a struct
+  Infof("=> POST %s", redact(this, rq))
+  if dryRun("POST", redact(this, rq)) {
+    return nil
+  }
+  key := journalKey("POST", redact(this, rq), []byte(f.Encode()))
+  if fromJournal("POST", redact(this, rq), key, &v) {
+    return nil
+  }
+  start := time.Now()
   resp, err := http.PostForm(makeQuery(this, rq), f)
+  observeCall(this, "POST", start, resp, err)
 
-  processResponce(resp, err, &v)
+  return processResponce("POST", redact(this, rq), key, resp, err, &v)
 }
 
-func GenPOSTJSON(this GenAPI, rq string, v interface{}, f interface{}) {
-  log.Printf("=> POST %s", rq)
+func GenPOSTJSON(this GenAPI, rq string, v interface{}, f interface{}) error {
+  Infof("=> POST %s", redact(this, rq))
+  if dryRun("POST", redact(this, rq)) {
+    return nil
+  }
   /* TODO check json errors */
   payload, _ := json.Marshal(f)
 
+  key := journalKey("POST", redact(this, rq), payload)
+  if fromJournal("POST", redact(this, rq), key, &v) {
+    return nil
+  }
+  start := time.Now()
   resp, err := http.Post(makeQuery(this, rq), "application/json", bytes.NewReader(payload))
-  processResponce(resp, err, &v)
+  observeCall(this, "POST", start, resp, err)
+  return processResponce("POST", redact(this, rq), key, resp, err, &v)
 }
 
-func processResponce(resp *http.Response, err error, v interface{}) {
+/* Key is for the journal, empty if the request changes nothing */
+func processResponce(method string, rq string, key string, resp *http.Response, err error, v interface{}) error {
+  var res error
   if err != nil {
-    log.Fatal(err)
+    /* The URL in there has the credentials */
+    if uerr, ok := err.(*url.Error); ok {
+      err = uerr.Err
+    }
+    res = &APIError{ method, rq, 0, err.Error() }
   } else {
     defer resp.Body.Close()
     body, _ := ioutil.ReadAll(resp.Body)
 
     if resp.StatusCode < 200 || resp.StatusCode > 299 {
-      log.Printf("HTTP request returned response %d\n", resp.StatusCode)
-      log.Printf("[ERROR] %s", string(body[:])) // TODO error return or smth?
-    } else if v != nil {
-      /* TODO check json errors */
-      json.Unmarshal(body, &v)
+      res = &APIError{ method, rq, resp.StatusCode, strings.TrimSpace(string(body[:])) }
+    } else {
+      if v != nil {
+        /* TODO check json errors */
+        json.Unmarshal(body, &v)
+      }
+      if len(key) > 0 {
+        entry := JournalEntry{ Key: key }
+        if v != nil {
+          entry.Response = string(body)
+        }
+        record(entry)
+      }
     }
 
     //log.Println(string(body[:]))
   }
+
+  if res != nil {
+    Errorf("Request failed: %s", res)
+  }
+  /* Reading doesn't change anything */
+  if method != "GET" {
+    calls = append(calls, CallResult{ method, rq, res, Correlation() })
+  }
+  return res
 }
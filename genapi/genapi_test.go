@@ -0,0 +1,73 @@
+package genapi
+
+import (
+  "regexp"
+  "testing"
+)
+
+func TestBranchPattern(t *testing.T) {
+  re := regexp.MustCompile(REGEX_GH_FEATURE)
+  cases := []struct {
+    branch  string
+    issue   string
+  }{
+    { "123-fix-login", "123" },
+    { "feature/GH-123", "123" },
+    { "issue-42", "42" },
+    { "feature/42-foo", "42" },
+    { "2024-q1-cleanup", "" },
+    { "2024-01-release", "" },
+    { "release-2024", "" },
+    { "42", "" },
+  }
+
+  for _, c := range cases {
+    got := ""
+    if res := re.FindStringSubmatch(c.branch); res != nil {
+      for _, v := range res[1:] {
+        if len(v) > 0 {
+          got = v
+          break
+        }
+      }
+    }
+    if got != c.issue {
+      t.Errorf("%q: got issue %q, want %q", c.branch, got, c.issue)
+    }
+  }
+}
+
+/* Credentials of the API, as Trello has them */
+type redactAPI struct {
+  query   string
+}
+
+func (api *redactAPI) AuthQuery() string {
+  return api.query
+}
+
+func (api *redactAPI) BaseURL() string {
+  return "https://api.example.com/"
+}
+
+func TestRedact(t *testing.T) {
+  trello := &redactAPI{ "key=0123456789abcdef&token=fedcba9876543210fedcba" }
+  cases := []struct {
+    api     GenAPI
+    rq      string
+    res     string
+  }{
+    { trello, "/cards/1/idList?value=2", "/cards/1/idList?value=2" },
+    { trello, "/token/fedcba9876543210fedcba/webhooks", "/token/<redacted>/webhooks" },
+    { trello, "/x?key=0123456789abcdef&token=fedcba9876543210fedcba", "/x?key=<redacted>&token=<redacted>" },
+    /* Too short to be a credential, replacing it would mangle everything */
+    { &redactAPI{ "key=1&token=abc" }, "/cards/1/abc", "/cards/1/abc" },
+    { &redactAPI{ "" }, "/cards/1", "/cards/1" },
+  }
+
+  for _, c := range cases {
+    if res := redact(c.api, c.rq); res != c.res {
+      t.Errorf("%q: got %q, want %q", c.rq, res, c.res)
+    }
+  }
+}
@@ -0,0 +1,73 @@
+/* Changes made by an event, so that it can be done again after an interruption without repeating them */
+package genapi
+
+import (
+  "crypto/sha1"
+  "encoding/hex"
+)
+
+/* A change that went through: the request without the credentials and a hash of what was sent, along with the answer */
+type JournalEntry struct {
+  Key       string  `json:"key"`
+  Response  string  `json:"response,omitempty"`
+}
+
+var journal struct {
+  event     string
+  previous  []JournalEntry  // done before the interruption, not to be done again
+  made      []JournalEntry
+}
+
+/* Called after every change recorded, the entries have to be kept somewhere in case we go down */
+var OnJournal func (entries []JournalEntry)
+
+/* Starts recording the changes made for the event, previous are the ones it made before it was interrupted.
+   Changes made in the meantime by anything else than the event are not recorded */
+func StartJournal(event string, previous []JournalEntry) {
+  journal.event = event
+  journal.previous = append([]JournalEntry{}, previous...)
+  journal.made = nil
+}
+
+/* Changes made so far, in this run or before the interruption */
+func Journal() []JournalEntry {
+  return append(append([]JournalEntry{}, journal.made...), journal.previous...)
+}
+
+func StopJournal() {
+  journal.event, journal.previous, journal.made = "", nil, nil
+}
+
+func journalKey(method string, rq string, payload []byte) string {
+  sum := sha1.Sum(payload)
+  return method + " " + rq + " " + hex.EncodeToString(sum[:])
+}
+
+func journaling() bool {
+  return len(journal.event) > 0 && journal.event == Correlation()
+}
+
+/* Takes the answer to the change if it was made before the interruption. Same changes are matched one to one, in order */
+func replayed(key string) (string, bool) {
+  if !journaling() {
+    return "", false
+  }
+  for i, v := range journal.previous {
+    if v.Key == key {
+      journal.previous = append(journal.previous[:i], journal.previous[i+1:]...)
+      record(v)
+      return v.Response, true
+    }
+  }
+  return "", false
+}
+
+func record(entry JournalEntry) {
+  if !journaling() {
+    return
+  }
+  journal.made = append(journal.made, entry)
+  if OnJournal != nil {
+    OnJournal(Journal())
+  }
+}
@@ -0,0 +1,146 @@
+package genapi
+
+import (
+  "fmt"
+  "net/http"
+  "net/http/httptest"
+  "net/url"
+  "reflect"
+  "strings"
+  "testing"
+)
+
+func TestJournal(t *testing.T) {
+  previous := []JournalEntry{
+    { Key: "POST /cards/ a", Response: `{"id":"1"}` },
+    { Key: "POST /cards/1/actions/comments b" },
+    { Key: "POST /cards/1/actions/comments b" },
+  }
+
+  cases := []struct {
+    name      string
+    event     string
+    keys      []string
+    replayed  []bool
+    left      int
+  }{
+    { "repeated", "e1", []string{ "POST /cards/ a", "POST /cards/1/actions/comments b" }, []bool{ true, true }, 1 },
+    { "one to one", "e1", []string{ "POST /cards/1/actions/comments b", "POST /cards/1/actions/comments b", "POST /cards/1/actions/comments b" }, []bool{ true, true, false }, 1 },
+    { "new ones", "e1", []string{ "PUT /cards/1/idList c" }, []bool{ false }, 3 },
+    /* Nothing else than the event gets the answers */
+    { "other event", "e2", []string{ "POST /cards/ a" }, []bool{ false }, 3 },
+  }
+
+  defer SetCorrelation("")
+  defer StopJournal()
+  for _, c := range cases {
+    SetCorrelation(c.event)
+    StartJournal("e1", previous)
+    for i, key := range c.keys {
+      if _, ok := replayed(key); ok != c.replayed[i] {
+        t.Errorf("%s: %s replayed %v, want %v", c.name, key, ok, c.replayed[i])
+      }
+    }
+    if len(journal.previous) != c.left {
+      t.Errorf("%s: %d left to replay, want %d", c.name, len(journal.previous), c.left)
+    }
+    /* Whatever was replayed counts as made, and the rest is still to be kept */
+    if c.event == "e1" && len(Journal()) != len(previous) {
+      t.Errorf("%s: journal has %d entries, want %d", c.name, len(Journal()), len(previous))
+    }
+  }
+}
+
+func TestJournalRecord(t *testing.T) {
+  var saved []JournalEntry
+  OnJournal = func (entries []JournalEntry) {
+    saved = entries
+  }
+  defer func () { OnJournal = nil }()
+  defer SetCorrelation("")
+  defer StopJournal()
+
+  SetCorrelation("e1")
+  StartJournal("e1", []JournalEntry{ { Key: "old" } })
+  record(JournalEntry{ Key: "new" })
+  if want := []JournalEntry{ { Key: "new" }, { Key: "old" } }; !reflect.DeepEqual(saved, want) {
+    t.Errorf("got %v, want %v", saved, want)
+  }
+
+  /* Stopped, nothing is recorded */
+  StopJournal()
+  record(JournalEntry{ Key: "later" })
+  if len(saved) != 2 {
+    t.Errorf("recorded after stopping: %v", saved)
+  }
+
+  if journalKey("POST", "/cards/", []byte("a")) == journalKey("POST", "/cards/", []byte("b")) {
+    t.Errorf("different payloads give the same key")
+  }
+}
+
+/* Talks to a local server, counting the requests */
+type testAPI struct {
+  url     string
+}
+
+func (api *testAPI) AuthQuery() string {
+  return "key=0123456789abcdef"
+}
+
+func (api *testAPI) BaseURL() string {
+  return api.url
+}
+
+func TestJournalRequests(t *testing.T) {
+  hits := 0
+  server := httptest.NewServer(http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
+    hits++
+    fmt.Fprintf(w, `{"id":"card%d"}`, hits)
+  }))
+  defer server.Close()
+  api := &testAPI{ server.URL }
+
+  defer SetCorrelation("")
+  defer StopJournal()
+  SetCorrelation("e1")
+
+  /* First run, interrupted after these */
+  StartJournal("e1", nil)
+  var first struct { Id string `json:"id"` }
+  GenPOSTForm(api, "/cards/", &first, url.Values{ "name": { "a" } })
+  GenPUT(api, "/cards/" + first.Id + "/idList?value=1")
+  done := Journal()
+
+  /* Second run makes the same changes and a new one */
+  StartJournal("e1", done)
+  var second struct { Id string `json:"id"` }
+  cases := []struct {
+    name    string
+    call    func () error
+    hits    int
+  }{
+    { "created before", func () error { return GenPOSTForm(api, "/cards/", &second, url.Values{ "name": { "a" } }) }, 2 },
+    { "moved before", func () error { return GenPUT(api, "/cards/" + second.Id + "/idList?value=1") }, 2 },
+    { "new", func () error { return GenPUT(api, "/cards/" + second.Id + "/idList?value=2") }, 3 },
+    { "other card", func () error { return GenPOSTForm(api, "/cards/", &second, url.Values{ "name": { "b" } }) }, 4 },
+  }
+
+  for _, c := range cases {
+    if err := c.call(); err != nil {
+      t.Errorf("%s: %s", c.name, err)
+    }
+    if hits != c.hits {
+      t.Errorf("%s: %d requests made, want %d", c.name, hits, c.hits)
+    }
+    if c.name == "created before" && second.Id != first.Id {
+      t.Errorf("%s: got card %s, want %s", c.name, second.Id, first.Id)
+    }
+  }
+  for _, v := range Journal() {
+    if strings.Contains(v.Key, "0123456789abcdef") {
+      t.Errorf("credentials in the journal: %s", v.Key)
+    }
+  }
+  TakeCalls()
+}
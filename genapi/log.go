@@ -0,0 +1,156 @@
+/* Levelled logging, either human readable or one JSON object per line */
+package genapi
+
+import (
+  "encoding/json"
+  "fmt"
+  "log"
+  "os"
+  "strings"
+  "sync"
+  "time"
+)
+
+type Level int
+
+const (
+  LevelDebug Level = iota
+  LevelInfo
+  LevelWarn
+  LevelError
+  LevelFatal
+)
+
+var levelNames = []string { "debug", "info", "warn", "error", "fatal" }
+
+func (level Level) String() string {
+  return levelNames[level]
+}
+
+var logger struct {
+  out         *log.Logger
+  json        bool
+  level       Level
+  correlation string
+  mutex       sync.Mutex // the event's goroutine sets it while others read it
+}
+
+func init() {
+  logger.out = log.New(os.Stderr, "", log.LstdFlags)
+  logger.level = LevelInfo
+}
+
+/* "text" (default) or "json" */
+func SetLogFormat(format string) error {
+  switch strings.ToLower(format) {
+  case "", "text":
+    logger.json = false
+    logger.out.SetFlags(log.LstdFlags)
+  case "json":
+    logger.json = true
+    logger.out.SetFlags(0)
+  default:
+    return fmt.Errorf("unknown log format %s, expected text or json", format)
+  }
+  return nil
+}
+
+/* Anything below the level is dropped */
+func SetLogLevel(name string) error {
+  for i, v := range levelNames {
+    if strings.EqualFold(v, name) {
+      logger.level = Level(i)
+      return nil
+    }
+  }
+  return fmt.Errorf("unknown log level %s, expected one of %s", name, strings.Join(levelNames, ", "))
+}
+
+/* Marks everything logged through the package functions as caused by the event with the id, empty string to stop.
+   Only the one processing an event under the big mutex may set it; code running alongside, like the shutdown
+   or the startup replays, logs through Background so that it doesn't pick up the id */
+func SetCorrelation(id string) {
+  logger.mutex.Lock()
+  defer logger.mutex.Unlock()
+  logger.correlation = id
+}
+
+func Correlation() string {
+  logger.mutex.Lock()
+  defer logger.mutex.Unlock()
+  return logger.correlation
+}
+
+type logEntry struct {
+  Time    string  `json:"time"`
+  Level   string  `json:"level"`
+  Event   string  `json:"event,omitempty"`
+  Message string  `json:"msg"`
+}
+
+func logf(event string, level Level, format string, v ...interface{}) {
+  if level < logger.level {
+    return
+  }
+
+  msg := strings.TrimRight(fmt.Sprintf(format, v...), "\n")
+  if logger.json {
+    line, _ := json.Marshal(logEntry{ time.Now().UTC().Format(time.RFC3339Nano), level.String(), event, msg })
+    logger.out.Print(string(line))
+  } else if len(event) > 0 {
+    logger.out.Printf("%-5s [%s] %s", strings.ToUpper(level.String()), event, msg)
+  } else {
+    logger.out.Printf("%-5s %s", strings.ToUpper(level.String()), msg)
+  }
+}
+
+/* Logs for the given event, whatever is being processed at the moment */
+type Logger struct {
+  Event   string
+}
+
+/* For code that doesn't process events */
+var Background = Logger{}
+
+func ForEvent(id string) Logger {
+  return Logger{ id }
+}
+
+func (l Logger) Debugf(format string, v ...interface{}) {
+  logf(l.Event, LevelDebug, format, v...)
+}
+
+func (l Logger) Infof(format string, v ...interface{}) {
+  logf(l.Event, LevelInfo, format, v...)
+}
+
+func (l Logger) Warnf(format string, v ...interface{}) {
+  logf(l.Event, LevelWarn, format, v...)
+}
+
+func (l Logger) Errorf(format string, v ...interface{}) {
+  logf(l.Event, LevelError, format, v...)
+}
+
+/* The package functions log for the event being processed, see SetCorrelation */
+func Debugf(format string, v ...interface{}) {
+  logf(Correlation(), LevelDebug, format, v...)
+}
+
+func Infof(format string, v ...interface{}) {
+  logf(Correlation(), LevelInfo, format, v...)
+}
+
+func Warnf(format string, v ...interface{}) {
+  logf(Correlation(), LevelWarn, format, v...)
+}
+
+func Errorf(format string, v ...interface{}) {
+  logf(Correlation(), LevelError, format, v...)
+}
+
+/* Logs and exits, same as log.Fatalf */
+func Fatalf(format string, v ...interface{}) {
+  logf(Correlation(), LevelFatal, format, v...)
+  os.Exit(1)
+}
@@ -0,0 +1,148 @@
+/* Markdown-aware text rewriting */
+package genapi
+
+import (
+  "regexp"
+  "strings"
+)
+
+const REGEX_MD_FENCE string = "^ {0,3}(`{3,}|~{3,})"
+const REGEX_MD_URL string = "(?i)(?:https?://|www\\.)[^[:space:]<>()]+"
+/* Prefix group keeps e-mails and paths out, third group catches @org/team */
+const REGEX_MD_MENTION string = "(?i)(^|[^a-z0-9_.+/@-])@([a-z0-9](?:[a-z0-9_-]{0,38}[a-z0-9])?)(/[a-z0-9][a-z0-9_.-]*)?"
+
+/* Applies the function to prose only, code blocks and inline code are kept as is */
+func MapProse(text string, f func (string) string) string {
+  res := ""
+  prose := ""
+  fence := ""
+  re := regexp.MustCompile(REGEX_MD_FENCE)
+
+  for _, line := range strings.SplitAfter(text, "\n") {
+    if len(fence) > 0 {
+      /* Inside a fenced block until the same or longer fence */
+      res = res + line
+      if strings.HasPrefix(strings.TrimLeft(line, " "), fence) {
+        fence = ""
+      }
+    } else if catch := re.FindStringSubmatch(line); catch != nil {
+      res = res + mapInline(prose, f) + line
+      prose, fence = "", catch[1]
+    } else {
+      prose = prose + line
+    }
+  }
+
+  return res + mapInline(prose, f)
+}
+
+/* Length of the backtick run at the position */
+func backticks(text string, at int) int {
+  return len(text[at:]) - len(strings.TrimLeft(text[at:], "`"))
+}
+
+/* Same but for inline code spans, which are delimited by backtick runs of equal length */
+func mapInline(text string, f func (string) string) string {
+  res := ""
+  for {
+    start := strings.Index(text, "`")
+    if start < 0 {
+      return res + f(text)
+    }
+    n := backticks(text, start)
+
+    /* Look for the closing run */
+    end := -1
+    for i := start + n; i < len(text); {
+      j := strings.Index(text[i:], "`")
+      if j < 0 {
+        break
+      }
+      if m := backticks(text, i + j); m == n {
+        end = i + j + n
+        break
+      } else {
+        i = i + j + m
+      }
+    }
+
+    if end < 0 { /* Unmatched backticks are just text */
+      res = res + f(text[:start + n])
+      text = text[start + n:]
+    } else {
+      res = res + f(text[:start]) + text[start:end]
+      text = text[end:]
+    }
+  }
+}
+
+/* Applies the function to everything but the matches of the regexp */
+func MapOutside(text string, regtxt string, f func (string) string) string {
+  res := ""
+  re := regexp.MustCompile(regtxt)
+  last := 0
+  for _, v := range re.FindAllStringIndex(text, -1) {
+    res = res + f(text[last:v[0]]) + text[v[0]:v[1]]
+    last = v[1]
+  }
+  return res + f(text[last:])
+}
+
+/* Like StrSub, but anchors and prefixes see the whole text */
+func subAll(text string, regtxt string, f StrSub_c) string {
+  res := ""
+  re := regexp.MustCompile(regtxt)
+  last := 0
+  for _, catch := range re.FindAllStringSubmatchIndex(text, -1) {
+    par := make([]string, len(catch)/2)
+    for i := range par {
+      if catch[i*2] >= 0 {
+        par[i] = text[catch[i*2]:catch[i*2+1]]
+      }
+    }
+    res = res + text[last:catch[0]] + f(par)
+    last = catch[1]
+  }
+  return res + text[last:]
+}
+
+/* Finds a user in the dictionary, GitHub doesn't care about the case */
+func lookupUser(dic map[string]string, user string) (string, bool) {
+  if res, ok := dic[user]; ok {
+    return res, true
+  }
+  for k, v := range dic {
+    if strings.EqualFold(k, user) {
+      return v, true
+    }
+  }
+  return "", false
+}
+
+/* Replaces @mentions using the dictionary, leaving unknown users, teams, code and URLs alone.
+   Returns the new text and the users not found in the dictionary */
+func TranslateMentions(text string, dic map[string]string) (string, []string) {
+  unknown := make([]string, 0)
+  seen := NewSet()
+
+  res := MapProse(text, func (prose string) string {
+    return MapOutside(prose, REGEX_MD_URL, func (part string) string {
+      return subAll(part, REGEX_MD_MENTION, func (v []string) string {
+        /* Teams have no counterpart */
+        if len(v[3]) > 0 {
+          return v[0]
+        }
+        if user, ok := lookupUser(dic, v[2]); ok && len(user) > 0 {
+          return v[1] + "@" + user
+        }
+        if !seen[v[2]] {
+          seen[v[2]] = true
+          unknown = append(unknown, v[2])
+        }
+        return v[0]
+      })
+    })
+  })
+
+  return res, unknown
+}
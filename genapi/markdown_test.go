@@ -0,0 +1,35 @@
+package genapi
+
+import (
+  "reflect"
+  "testing"
+)
+
+func TestTranslateMentions(t *testing.T) {
+  dic := map[string]string{ "octocat": "cat", "Hubot": "robot", "nobody": "" }
+  cases := []struct {
+    text    string
+    res     string
+    unknown []string
+  }{
+    { "@octocat look", "@cat look", []string{} },
+    { "cc @OctoCat, @hubot.", "cc @cat, @robot.", []string{} },
+    { "@stranger and @stranger", "@stranger and @stranger", []string{ "stranger" } },
+    { "@nobody", "@nobody", []string{ "nobody" } },
+    { "mail me@octocat.com", "mail me@octocat.com", []string{} },
+    { "@org/team and @octocat", "@org/team and @cat", []string{} },
+    { "see https://example.com/@octocat", "see https://example.com/@octocat", []string{} },
+    { "run `@octocat` now", "run `@octocat` now", []string{} },
+    { "```\n@octocat\n```\n@octocat", "```\n@octocat\n```\n@cat", []string{} },
+  }
+
+  for _, c := range cases {
+    res, unknown := TranslateMentions(c.text, dic)
+    if res != c.res {
+      t.Errorf("%q: got %q, want %q", c.text, res, c.res)
+    }
+    if !reflect.DeepEqual(unknown, c.unknown) {
+      t.Errorf("%q: got unknown %v, want %v", c.text, unknown, c.unknown)
+    }
+  }
+}
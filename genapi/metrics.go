@@ -0,0 +1,198 @@
+/* A tiny registry of metrics exposed in Prometheus text format, we need way too little to pull the client in */
+package genapi
+
+import (
+  "fmt"
+  "io"
+  "math"
+  "sort"
+  "strings"
+  "sync"
+)
+
+type metric interface {
+  write(w io.Writer)
+}
+
+/* Handlers update metrics under the big mutex, but scrapes don't take it */
+var metricsMutex sync.Mutex
+var registry []metric
+
+/* Label values of a single series, keyed by their joined form */
+type series struct {
+  labels  []string
+  values  map[string][]string
+}
+
+func newSeries(labels []string) series {
+  return series{ labels, make(map[string][]string) }
+}
+
+func (s *series) key(values []string) string {
+  if len(values) != len(s.labels) {
+    panic(fmt.Sprintf("[BUG] Expected %d label values, got %d", len(s.labels), len(values)))
+  }
+  key := strings.Join(values, "\xff")
+  if _, ok := s.values[key]; !ok {
+    s.values[key] = values
+  }
+  return key
+}
+
+/* Keys in a stable order, so that scrapes are easy to diff */
+func (s *series) keys() []string {
+  res := make([]string, 0, len(s.values))
+  for k := range s.values {
+    res = append(res, k)
+  }
+  sort.Strings(res)
+  return res
+}
+
+func escapeLabel(value string) string {
+  return strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n").Replace(value)
+}
+
+/* Renders {a="x",b="y"}, extra is for histogram buckets */
+func (s *series) render(key string, extra ...string) string {
+  pairs := make([]string, 0, len(s.labels) + 1)
+  for i, v := range s.values[key] {
+    pairs = append(pairs, s.labels[i] + "=\"" + escapeLabel(v) + "\"")
+  }
+  for i := 0; i + 1 < len(extra); i += 2 {
+    pairs = append(pairs, extra[i] + "=\"" + escapeLabel(extra[i+1]) + "\"")
+  }
+  if len(pairs) == 0 {
+    return ""
+  }
+  return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+  switch {
+  case math.IsInf(v, 1):
+    return "+Inf"
+  case v == math.Trunc(v) && math.Abs(v) < 1e15:
+    return fmt.Sprintf("%d", int64(v))
+  }
+  return fmt.Sprintf("%g", v)
+}
+
+/* Counters and gauges only differ in what is allowed to do with them */
+type Counter struct {
+  name    string
+  help    string
+  kind    string
+  series
+  counts  map[string]float64
+}
+
+type Gauge struct {
+  Counter
+}
+
+func newCounter(name string, help string, kind string, labels []string) *Counter {
+  return &Counter{ name, help, kind, newSeries(labels), make(map[string]float64) }
+}
+
+func NewCounter(name string, help string, labels ...string) *Counter {
+  res := newCounter(name, help, "counter", labels)
+  register(res)
+  return res
+}
+
+func NewGauge(name string, help string, labels ...string) *Gauge {
+  res := &Gauge{ *newCounter(name, help, "gauge", labels) }
+  register(res)
+  return res
+}
+
+func register(m metric) {
+  metricsMutex.Lock()
+  defer metricsMutex.Unlock()
+  registry = append(registry, m)
+}
+
+func (c *Counter) Inc(values ...string) {
+  c.add(1, values)
+}
+
+func (c *Counter) add(delta float64, values []string) {
+  metricsMutex.Lock()
+  defer metricsMutex.Unlock()
+  c.counts[c.key(values)] += delta
+}
+
+func (g *Gauge) Set(v float64, values ...string) {
+  metricsMutex.Lock()
+  defer metricsMutex.Unlock()
+  g.counts[g.key(values)] = v
+}
+
+func (g *Gauge) Add(delta float64, values ...string) {
+  g.add(delta, values)
+}
+
+func (c *Counter) write(w io.Writer) {
+  fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", c.name, c.help, c.name, c.kind)
+  for _, k := range c.keys() {
+    fmt.Fprintf(w, "%s%s %s\n", c.name, c.render(k), formatValue(c.counts[k]))
+  }
+}
+
+type Histogram struct {
+  name    string
+  help    string
+  buckets []float64
+  series
+  counts  map[string][]uint64
+  sums    map[string]float64
+}
+
+/* Roughly what an HTTP call to Trello or GitHub takes */
+var DefaultBuckets = []float64 { 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30 }
+
+func NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+  res := &Histogram{ name, help, buckets, newSeries(labels), make(map[string][]uint64), make(map[string]float64) }
+  register(res)
+  return res
+}
+
+func (h *Histogram) Observe(v float64, values ...string) {
+  metricsMutex.Lock()
+  defer metricsMutex.Unlock()
+
+  key := h.key(values)
+  if h.counts[key] == nil {
+    h.counts[key] = make([]uint64, len(h.buckets) + 1) // the last one is +Inf
+  }
+  for i, b := range h.buckets {
+    if v <= b {
+      h.counts[key][i]++
+    }
+  }
+  h.counts[key][len(h.buckets)]++
+  h.sums[key] += v
+}
+
+func (h *Histogram) write(w io.Writer) {
+  fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+  for _, k := range h.keys() {
+    for i, b := range h.buckets {
+      fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.render(k, "le", formatValue(b)), h.counts[k][i])
+    }
+    total := h.counts[k][len(h.buckets)]
+    fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.render(k, "le", "+Inf"), total)
+    fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.render(k), formatValue(h.sums[k]))
+    fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.render(k), total)
+  }
+}
+
+/* Dumps everything registered so far */
+func WriteMetrics(w io.Writer) {
+  metricsMutex.Lock()
+  defer metricsMutex.Unlock()
+  for _, m := range registry {
+    m.write(w)
+  }
+}
@@ -0,0 +1,82 @@
+package genapi
+
+import (
+  "bytes"
+  "math"
+  "testing"
+)
+
+func TestFormatValue(t *testing.T) {
+  cases := []struct {
+    v       float64
+    res     string
+  }{
+    { 0, "0" },
+    { 42, "42" },
+    { -3, "-3" },
+    { 0.05, "0.05" },
+    { 2.5, "2.5" },
+    { 1e20, "1e+20" },
+    { math.Inf(1), "+Inf" },
+  }
+
+  for _, c := range cases {
+    if res := formatValue(c.v); res != c.res {
+      t.Errorf("%v: got %q, want %q", c.v, res, c.res)
+    }
+  }
+}
+
+func TestEscapeLabel(t *testing.T) {
+  cases := []struct {
+    value   string
+    res     string
+  }{
+    { "plain", "plain" },
+    { "a\"b", "a\\\"b" },
+    { "back\\slash", "back\\\\slash" },
+    { "two\nlines", "two\\nlines" },
+  }
+
+  for _, c := range cases {
+    if res := escapeLabel(c.value); res != c.res {
+      t.Errorf("%q: got %q, want %q", c.value, res, c.res)
+    }
+  }
+}
+
+/* Not registered, so that the tests don't show up in WriteMetrics */
+func TestMetricsWrite(t *testing.T) {
+  counter := newCounter("test_total", "Things.", "counter", []string{ "api", "status" })
+  counter.Inc("b", "200")
+  counter.Inc("a", "5\"00")
+  counter.Inc("b", "200")
+
+  histogram := &Histogram{ "test_seconds", "Time.", []float64{ 0.1, 1 }, newSeries(nil), make(map[string][]uint64), make(map[string]float64) }
+  histogram.Observe(0.05)
+  histogram.Observe(0.5)
+  histogram.Observe(3)
+
+  cases := []struct {
+    m       metric
+    res     string
+  }{
+    { counter, "# HELP test_total Things.\n# TYPE test_total counter\n" +
+      "test_total{api=\"a\",status=\"5\\\"00\"} 1\n" +
+      "test_total{api=\"b\",status=\"200\"} 2\n" },
+    { histogram, "# HELP test_seconds Time.\n# TYPE test_seconds histogram\n" +
+      "test_seconds_bucket{le=\"0.1\"} 1\n" +
+      "test_seconds_bucket{le=\"1\"} 2\n" +
+      "test_seconds_bucket{le=\"+Inf\"} 3\n" +
+      "test_seconds_sum 3.55\n" +
+      "test_seconds_count 3\n" },
+  }
+
+  for _, c := range cases {
+    var buf bytes.Buffer
+    c.m.write(&buf)
+    if buf.String() != c.res {
+      t.Errorf("got\n%s\nwant\n%s", buf.String(), c.res)
+    }
+  }
+}
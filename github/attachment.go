@@ -0,0 +1,81 @@
+/* Operations with files attached to GitHub issues */
+package github
+
+import (
+  . "github.com/ErintLabs/trellohub/genapi"
+  "fmt"
+  "regexp"
+  "strings"
+)
+
+/* Trello files are listed in a section of the body we manage ourselves */
+const attachmentsStart string = "<!-- trellohub:attachments -->"
+const attachmentsEnd string = "<!-- /trellohub:attachments -->"
+
+type Attachment struct {
+  Name    string
+  URL     string
+}
+
+/* Cuts the attachments section out of the body and parses it, same as with checklists */
+func (issue *Issue) GenAttachments() {
+  issue.Attachments = nil
+
+  start := strings.Index(issue.Body, attachmentsStart)
+  if start < 0 {
+    return
+  }
+  end := len(issue.Body)
+  if i := strings.Index(issue.Body[start:], attachmentsEnd); i >= 0 {
+    end = start + i + len(attachmentsEnd)
+  }
+
+  re := regexp.MustCompile(REGEX_GH_ATTACHMENT)
+  for _, v := range re.FindAllStringSubmatch(issue.Body[start:end], -1) {
+    issue.Attachments = append(issue.Attachments, Attachment{ v[1], v[2] })
+  }
+  issue.Body = strings.TrimRight(issue.Body[:start], "\r\n") + issue.Body[end:]
+}
+
+/* Renders the section to be put at the end of the body */
+func (issue *Issue) RenderAttachments() string {
+  if len(issue.Attachments) == 0 {
+    return ""
+  }
+
+  res := "\r\n\r\n" + attachmentsStart + "\r\n### Attachments"
+  for _, v := range issue.Attachments {
+    res = res + fmt.Sprintf("\r\n- [%s](%s)", v.Name, v.URL)
+  }
+  return res + "\r\n" + attachmentsEnd
+}
+
+/* Adds a file to the section, returns false if it's there already */
+func (issue *Issue) AddAttachment(name string, url string) bool {
+  for _, v := range issue.Attachments {
+    if v.URL == url {
+      return false
+    }
+  }
+
+  /* Brackets would break the link */
+  name = strings.NewReplacer("[", "(", "]", ")").Replace(name)
+  issue.Attachments = append(issue.Attachments, Attachment{ name, url })
+  return true
+}
+
+/* Lists files uploaded to GitHub that the body refers to */
+func (issue *Issue) Uploads() []string {
+  res := make([]string, 0)
+  seen := NewSet()
+
+  re := regexp.MustCompile(REGEX_GH_UPLOAD)
+  for _, v := range re.FindAllString(issue.Body, -1) {
+    if !seen[v] {
+      seen[v] = true
+      res = append(res, v)
+    }
+  }
+
+  return res
+}
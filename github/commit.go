@@ -0,0 +1,182 @@
+/* Operations with GitHub commit ranges */
+package github
+
+import (
+  . "github.com/ErintLabs/trellohub/genapi"
+  "net/http"
+  "strconv"
+  "regexp"
+)
+
+type comparison struct {
+  Status  string      `json:"status"`
+  Total   int         `json:"total_commits"`
+  Commits []GitCommit `json:"commits"`
+}
+
+/* Lists commits reachable from head but not from base, page by page. Either all of them or an error */
+func (github *GitHub) Compare(repoid string, base string, head string) ([]GitCommit, error) {
+  res := make([]GitCommit, 0)
+
+  for page := 1; ; page++ {
+    var data comparison
+    if err := GenGET(github, "repos/" + repoid + "/compare/" + base + "..." + head +
+      "?per_page=100&page=" + strconv.Itoa(page), &data); err != nil {
+      return nil, err
+    }
+    res = append(res, data.Commits...)
+
+    if len(data.Commits) == 0 || len(res) >= data.Total {
+      break
+    }
+  }
+
+  return res, nil
+}
+
+/* Checks whether the commit is still in the history of the ref */
+func (github *GitHub) Reachable(repoid string, sha string, ref string) (bool, error) {
+  var data comparison
+  if err := GenGET(github, "repos/" + repoid + "/compare/" + sha + "..." + ref, &data); err != nil {
+    /* Force-pushed away and garbage collected */
+    if apierr, ok := err.(*APIError); ok && apierr.Status == http.StatusNotFound {
+      return false, nil
+    }
+    return false, err
+  }
+  return data.Status != "diverged" && data.Status != "behind", nil
+}
+
+/* An issue or a Trello card referenced in commits, along with the commits that did it */
+type Reference struct {
+  Issue   *Issue
+  Card    string    // short link, if it's a card
+  Closing bool      // or only mentioned
+  Commits []string
+  Described bool    // in the pull request description rather than in a commit
+}
+
+func (ref *Reference) String() string {
+  if ref.Issue != nil {
+    return ref.Issue.String()
+  }
+  return "trello:" + ref.Card
+}
+
+/* Parses commit messages, one reference per issue and kind */
+func (github *GitHub) collectRefs(commits []GitCommit, repoid string) []*Reference {
+  res := make([]*Reference, 0)
+  byKey := make(map[string]*Reference)
+  seen := NewSet()
+
+  add := func (ref *Reference, sha string) {
+    key := ref.String() + " " + strconv.FormatBool(ref.Closing)
+    if known := byKey[key]; known != nil {
+      ref = known
+    } else {
+      byKey[key] = ref
+      res = append(res, ref)
+    }
+    if len(sha) > 0 {
+      ref.Commits = append(ref.Commits, sha)
+    } else {
+      ref.Described = true
+    }
+  }
+  cardre := regexp.MustCompile(REGEX_TRELLO_CARD)
+
+  revert := regexp.MustCompile(REGEX_GH_REVERT)
+
+  for _, v := range commits {
+    /* Head commit comes twice in pushes */
+    if len(v.Sha) > 0 {
+      if seen[v.Sha] {
+        continue
+      }
+      seen[v.Sha] = true
+    }
+    /* Reverts quote the original message, those are not fixes */
+    if revert.MatchString(v.Commit.Message) {
+      continue
+    }
+    closing, mentions := github.extractIssueIds(v.Commit.Message, repoid)
+    for _, issue := range closing {
+      add(&Reference{ Issue: issue, Closing: true }, v.Sha)
+    }
+    for _, issue := range mentions {
+      add(&Reference{ Issue: issue }, v.Sha)
+    }
+    /* Cards are moved like closed issues */
+    for _, catch := range cardre.FindAllStringSubmatch(v.Commit.Message, 256) {
+      add(&Reference{ Card: catch[1], Closing: true }, v.Sha)
+    }
+  }
+
+  return res
+}
+
+/* Issues closed by the commits between base and head */
+func (github *GitHub) IssuesBetween(repoid string, base string, head string) ([]*Reference, error) {
+  commits, err := github.Compare(repoid, base, head)
+  if err != nil {
+    return nil, err
+  }
+  return github.collectRefs(commits, repoid), nil
+}
+
+/* Retrieves a single commit */
+func (github *GitHub) GetCommit(repoid string, sha string) (GitCommit, error) {
+  var data GitCommit
+  err := GenGET(github, "repos/" + repoid + "/commits/" + sha, &data)
+  return data, err
+}
+
+/* A commit undoing another one, along with the issues the original one closed */
+type Revert struct {
+  Commit    string
+  Reverted  string
+  Issues    []*Issue
+}
+
+/* Finds reverts among the commits */
+func (github *GitHub) collectReverts(commits []GitCommit, repoid string) []*Revert {
+  return github.findReverts(commits, repoid, func (sha string) (GitCommit, error) {
+    return github.GetCommit(repoid, sha)
+  })
+}
+
+/* Same, reading the reverted commits with getCommit */
+func (github *GitHub) findReverts(commits []GitCommit, repoid string, getCommit func (sha string) (GitCommit, error)) []*Revert {
+  res := make([]*Revert, 0)
+  seen := NewSet()
+  re := regexp.MustCompile(REGEX_GH_REVERT)
+
+  for _, v := range commits {
+    if seen[v.Sha] {
+      continue
+    }
+    seen[v.Sha] = true
+
+    if catch := re.FindStringSubmatch(v.Commit.Message); catch != nil {
+      original, err := getCommit(catch[1])
+      if err != nil {
+        Errorf("Can't read commit %s reverted by %s: %s", catch[1], v.Sha, err)
+        continue
+      }
+      /* Reverting a revert applies the fix again, the quoted message is not what it closes */
+      if re.MatchString(original.Commit.Message) {
+        Infof("Commit %s reverts the revert %s, nothing to take back.", v.Sha, catch[1])
+        continue
+      }
+      closing, _ := github.extractIssueIds(original.Commit.Message, repoid)
+      res = append(res, &Revert{ v.Sha, catch[1], closing })
+    }
+  }
+
+  return res
+}
+
+/* Link to the commit page */
+func CommitURL(repoid string, sha string) string {
+  return "https://github.com/" + repoid + "/commit/" + sha
+}
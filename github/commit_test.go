@@ -0,0 +1,118 @@
+package github
+
+import (
+  "errors"
+  "reflect"
+  "testing"
+)
+
+/* Knows the issues #1 to #5 of owner/repo, so that nothing has to be asked */
+func testGitHub() *GitHub {
+  res := New("token")
+  res.SetKeywords(DefaultCloseKeywords, DefaultMentionKeywords)
+  for i := 1; i <= 5; i++ {
+    issue := &Issue{ RepoId: "owner/repo", IssueNo: i, github: res }
+    issue.cache()
+  }
+  return res
+}
+
+func commit(sha string, message string) GitCommit {
+  return GitCommit{ Sha: sha, Commit: Commit{ Message: message } }
+}
+
+/* Renders references as owner/repo#1 closed by a,b */
+func describeRefs(refs []*Reference) []string {
+  res := make([]string, 0, len(refs))
+  for _, v := range refs {
+    kind := "mentioned"
+    if v.Closing {
+      kind = "closed"
+    }
+    line := v.String() + " " + kind + " by"
+    for _, sha := range v.Commits {
+      line += " " + sha
+    }
+    if v.Described {
+      line += " description"
+    }
+    res = append(res, line)
+  }
+  return res
+}
+
+func TestCollectRefs(t *testing.T) {
+  cases := []struct {
+    name    string
+    commits []GitCommit
+    refs    []string
+  }{
+    { "single", []GitCommit{ commit("a", "Fix #1") }, []string{ "owner/repo#1 closed by a" } },
+    { "both kinds", []GitCommit{ commit("a", "Fixes #1, refs #2") },
+      []string{ "owner/repo#1 closed by a", "owner/repo#2 mentioned by a" } },
+    { "several commits", []GitCommit{ commit("a", "Fix #1"), commit("b", "closes #1") }, []string{ "owner/repo#1 closed by a b" } },
+    /* The head commit comes twice in pushes, and large pushes are fetched on top of the payload */
+    { "same commit twice", []GitCommit{ commit("a", "Fix #1"), commit("b", "wip #3"), commit("a", "Fix #1") },
+      []string{ "owner/repo#1 closed by a", "owner/repo#3 mentioned by b" } },
+    { "description", []GitCommit{ commit("a", "Fix #1"), commit("", "Fixes #1 and #2") }, []string{ "owner/repo#1 closed by a description" } },
+    { "nothing", []GitCommit{ commit("a", "Fix typo") }, []string{} },
+    { "cards", []GitCommit{ commit("a", "See https://trello.com/c/AbCd1234/12-login and [trello:ZzYy9876]"), commit("b", "More for trello.com/c/AbCd1234") },
+      []string{ "trello:AbCd1234 closed by a b", "trello:ZzYy9876 closed by a" } },
+    { "cards and issues", []GitCommit{ commit("a", "Fix #1 [trello:AbCd1234]") }, []string{ "owner/repo#1 closed by a", "trello:AbCd1234 closed by a" } },
+    { "not a card", []GitCommit{ commit("a", "trello.com/b/AbCd1234 [trello:short]") }, []string{} },
+    /* Reverts quote the message of the fix */
+    { "revert", []GitCommit{ commit("b", "Revert \"Fix #1\"\n\nThis reverts commit 0123456789abcdef.") }, []string{} },
+  }
+
+  github := testGitHub()
+  for _, c := range cases {
+    if refs := describeRefs(github.collectRefs(c.commits, "owner/repo")); !reflect.DeepEqual(refs, c.refs) {
+      t.Errorf("%s: got %v, want %v", c.name, refs, c.refs)
+    }
+  }
+}
+
+func TestCollectReverts(t *testing.T) {
+  history := map[string]GitCommit{
+    "1111111": commit("1111111", "Fix #1, fixes #2"),
+    "2222222": commit("2222222", "Revert \"Fix #1\"\n\nThis reverts commit 1111111."),
+    "3333333": commit("3333333", "Refactor, refs #3"),
+  }
+  getCommit := func (sha string) (GitCommit, error) {
+    if v, ok := history[sha]; ok {
+      return v, nil
+    }
+    return GitCommit{}, errors.New("404 Not Found")
+  }
+
+  cases := []struct {
+    name    string
+    commits []GitCommit
+    reverts []string
+  }{
+    { "revert", []GitCommit{ commit("a", "Revert \"Fix #1\"\n\nThis reverts commit 1111111.") },
+      []string{ "a reverts 1111111: owner/repo#1 owner/repo#2" } },
+    { "same revert twice", []GitCommit{ commit("a", "This reverts commit 1111111."), commit("a", "This reverts commit 1111111.") },
+      []string{ "a reverts 1111111: owner/repo#1 owner/repo#2" } },
+    /* Taking the revert back puts the fix back in */
+    { "revert of a revert", []GitCommit{ commit("b", "This reverts commit 2222222.") }, []string{} },
+    { "closes nothing", []GitCommit{ commit("c", "This reverts commit 3333333.") }, []string{ "c reverts 3333333:" } },
+    { "unknown commit", []GitCommit{ commit("d", "This reverts commit 4444444.") }, []string{} },
+    { "no revert", []GitCommit{ commit("e", "Fix #1") }, []string{} },
+  }
+
+  github := testGitHub()
+  for _, c := range cases {
+    res := make([]string, 0)
+    for _, v := range github.findReverts(c.commits, "owner/repo", getCommit) {
+      line := v.Commit + " reverts " + v.Reverted + ":"
+      for _, issue := range v.Issues {
+        line += " " + issue.String()
+      }
+      res = append(res, line)
+    }
+    if !reflect.DeepEqual(res, c.reverts) {
+      t.Errorf("%s: got %v, want %v", c.name, res, c.reverts)
+    }
+  }
+}
@@ -0,0 +1,50 @@
+/* Operations with GitHub deployments */
+package github
+
+import (
+  . "github.com/ErintLabs/trellohub/genapi"
+  "net/url"
+  "strconv"
+)
+
+type Deployment struct {
+  Id          int       `json:"id"`
+  Sha         string    `json:"sha"`
+  Environment string    `json:"environment"`
+}
+
+type DeploymentStatus struct {
+  State       string    `json:"state"`
+}
+
+/* Finds the SHA of the last successful deployment to the environment before the given one,
+   empty string if there was none */
+func (github *GitHub) PreviousDeployment(repoid string, environment string, before int) (string, error) {
+  for page := 1; ; page++ {
+    var deployments []Deployment
+    if err := GenGET(github, "repos/" + repoid + "/deployments?environment=" + url.QueryEscape(environment) +
+      "&per_page=100&page=" + strconv.Itoa(page), &deployments); err != nil {
+      return "", err
+    }
+
+    /* Newest come first, so the first success is the one */
+    for _, v := range deployments {
+      if v.Id >= before {
+        continue
+      }
+
+      /* Only the latest status matters */
+      var statuses []DeploymentStatus
+      if err := GenGET(github, "repos/" + repoid + "/deployments/" + strconv.Itoa(v.Id) + "/statuses?per_page=1", &statuses); err != nil {
+        return "", err
+      }
+      if len(statuses) > 0 && statuses[0].State == "success" {
+        return v.Sha, nil
+      }
+    }
+
+    if len(deployments) < 100 {
+      return "", nil
+    }
+  }
+}
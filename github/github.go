@@ -1,7 +1,7 @@
 package github
 
 import (
-  "log"
+  "regexp"
   . "github.com/ErintLabs/trellohub/genapi"
 )
 
@@ -16,20 +16,35 @@ type Payload struct {
   Repo    Repo      `json:"repository"`
   Assignees
   Label   Label     `json:"label"`
+  Review  struct {
+    State string    `json:"state"`
+  }                 `json:"review"`
+  Deployment        Deployment        `json:"deployment"`
+  DeploymentStatus  DeploymentStatus  `json:"deployment_status"`
+  Release           Release           `json:"release"`
+  /* Only for synchronize */
+  Before  string    `json:"before"`
+  After   string    `json:"after"`
   // TODO: remove when #32 is fixed
   Changes struct {
     Body  struct {
       From string   `json:"from"`
     }               `json:"body"`
+    /* Only for label edits */
+    Name  struct {
+      From string   `json:"from"`
+    }               `json:"name"`
   }                 `json:"changes"`
 }
 
 /* Make some fields private maybe */
 type Commit    struct {
+  Id      string  `json:"id"`
   Message string  `json:"message"`
 }
 
 type GitCommit struct {
+  Sha    string     `json:"sha"`
   Commit Commit     `json:"commit"`
 }
 
@@ -39,6 +54,7 @@ type Assignees struct {
 }
 
 type WebHook struct {
+  Id        int       `json:"id,omitempty"`
   Name      string    `json:"name"`
   Active    bool      `json:"active"`
   Events    []string  `json:"events"`
@@ -46,6 +62,12 @@ type WebHook struct {
     Type    string    `json:"content_type"`
     URL     string    `json:"url"`
   }                   `json:"config"`
+  /* Only when reading */
+  LastResponse  *struct {
+    Code    int       `json:"code"`
+    Status  string    `json:"status"`
+    Message string    `json:"message"`
+  }                   `json:"last_response,omitempty"`
 }
 
 func New(token string) *GitHub {
@@ -53,6 +75,8 @@ func New(token string) *GitHub {
   t.Token = token
   t.issueBySpec = make(map[string]*Issue)
   t.pullBySpec = make(map[string]*Pull)
+  t.profiles = make(map[string]GitProfile)
+  t.SetKeywords(DefaultCloseKeywords, DefaultMentionKeywords)
 
   return t
 }
@@ -61,6 +85,18 @@ type GitHub struct {
   Token         string
   issueBySpec   map[string]*Issue
   pullBySpec    map[string]*Pull
+  profiles      map[string]GitProfile
+  closeRe       *regexp.Regexp
+  mentionRe     *regexp.Regexp
+}
+
+/* Number of entries in each of the caches */
+func (github *GitHub) CacheSizes() map[string]int {
+  return map[string]int {
+    "github_issues": len(github.issueBySpec),
+    "github_pulls": len(github.pullBySpec),
+    "github_profiles": len(github.profiles),
+  }
 }
 
 func (github *GitHub) AuthQuery() string {
@@ -71,25 +107,43 @@ func (github *GitHub) BaseURL() string {
   return "https://api.github.com/"
 }
 
+/* Lists webhooks installed on a repository */
+func (github *GitHub) Hooks(repoid string) ([]WebHook, error) {
+  var hooks []WebHook
+  err := GenGET(github, "repos/" + repoid + "/hooks", &hooks)
+  return hooks, err
+}
+
+/* Checks that the token is good */
+func (github *GitHub) CheckToken() error {
+  return GenGET(github, "user", nil)
+}
+
 /* Check and install webhooks on a repository */
 // TODO secret
 // TODO don't fail if we don't have access
-func (github *GitHub) EnsureHook(repoid string, callbackURLbase string) {
+func (github *GitHub) EnsureHook(repoid string, callbackURLbase string) error {
   /* Retrieving previously installed hooks */
-  var hooks []WebHook
-  GenGET(github, "repos/" + repoid + "/hooks", &hooks)
+  hooks, err := github.Hooks(repoid)
+  if err != nil {
+    return err
+  }
 
   hookevts := map[string] struct { event string; found bool } {
     "/issues": { "issues", false },
     "/pull": { "pull_request", false},
     "/push": { "push", false },
+    "/review": { "pull_request_review", false },
+    "/deploy": { "deployment_status", false },
+    "/release": { "release", false },
+    "/label": { "label", false },
   }
 
   /* Checking if there is a hook with exact same parameters */
   for _, v := range hooks {
     for k, f := range hookevts {
       if len(v.Events) > 0 && v.Events[0] == f.event && v.Config.URL == callbackURLbase + k {
-        log.Printf("Found an existing GitHub hook at %s for %s, reusing.", v.Config.URL, repoid)
+        Infof("Found an existing GitHub hook at %s for %s, reusing.", v.Config.URL, repoid)
         hookevts[k] = struct{event string; found bool}{ f.event, true }
       }
     }
@@ -105,8 +159,11 @@ func (github *GitHub) EnsureHook(repoid string, callbackURLbase string) {
       wh.Events = []string{ f.event }
       wh.Config.Type = "json"
       wh.Config.URL = callbackURLbase + k
-      log.Printf("Creating a hook for %s at %s", wh.Config.URL, repoid)
-      GenPOSTJSON(github, "repos/" + repoid + "/hooks", nil, &wh)
+      Infof("Creating a hook for %s at %s", wh.Config.URL, repoid)
+      if err := GenPOSTJSON(github, "repos/" + repoid + "/hooks", nil, &wh); err != nil {
+        return err
+      }
     }
   }
+  return nil
 }
@@ -3,6 +3,7 @@ package github
 
 import (
   . "github.com/ErintLabs/trellohub/genapi"
+  "regexp"
   "strconv"
 )
 
@@ -19,6 +20,7 @@ type Issue struct {
   Labels      Set             `json:"-"`
   Members     Set             `json:"-"`
   Checklist   []CheckItem     `json:"-"`
+  Attachments []Attachment    `json:"-"`
   // TODO: remove when #32 is fixed
   Newbody     string          `json:"-"`
 }
@@ -46,9 +48,18 @@ func (issue *Issue) cache() {
 }
 
 /* Retrieves the issue data from the server */
-func (issue *Issue) update() {
-  GenGET(issue.github, issue.ApiURL(), issue)
+func (issue *Issue) update() error {
+  if err := GenGET(issue.github, issue.ApiURL(), issue); err != nil {
+    return err
+  }
+  issue.GenAttachments()
   issue.GenChecklist()
+  return nil
+}
+
+/* Forces the issue to be re-read from the server */
+func (issue *Issue) Reload() {
+  issue.update()
 }
 
 /* Parses body and outputs the checklists, also modifies body */
@@ -82,11 +93,54 @@ func (github *GitHub) GetIssue(repoid string, issueno int) *Issue {
   }
 }
 
+/* Same as GetIssue, but only for issues that exist, nothing is cached otherwise */
+func (github *GitHub) LookupIssue(repoid string, issueno int) (*Issue, error) {
+  res := &Issue{ RepoId: repoid, IssueNo: issueno, github: github }
+  if issue := github.issueBySpec[res.String()]; issue != nil {
+    return issue, nil
+  }
+  if err := res.update(); err != nil {
+    return nil, err
+  }
+  res.cache()
+  res.Members = NewSet()
+  res.Labels = NewSet()
+  return res, nil
+}
+
+/* Which of the known issues a request to GitHub was about, nil if none */
+func (github *GitHub) IssueByQuery(query string) *Issue {
+  re := regexp.MustCompile("^repos/" + REGEX_GH_OWNREPO + "/(?:issues|pulls)/([0-9]+)")
+  if res := re.FindStringSubmatch(query); res != nil {
+    spec := res[1] + "#" + res[2]
+    if issue := github.issueBySpec[spec]; issue != nil {
+      return issue
+    }
+    if pull := github.pullBySpec[spec]; pull != nil {
+      return &pull.Issue
+    }
+  }
+  return nil
+}
+
 /* Updates Issue body/title */
-func (issue *Issue) UpdateBody(newbody string) {
-  GenPATCHJSON(issue.github, issue.ApiURL(), &struct { Body string `json:"body"` }{ newbody })
+func (issue *Issue) UpdateBody(newbody string) error {
+  return GenPATCHJSON(issue.github, issue.ApiURL(), &struct { Body string `json:"body"` }{ newbody })
+}
+
+func (issue *Issue) UpdateTitle(newtitle string) error {
+  return GenPATCHJSON(issue.github, issue.ApiURL(), &struct { Title string `json:"title"` }{ newtitle })
+}
+
+/* Leave a comment on the issue, returns its id */
+func (issue *Issue) AddComment(text string) (int, error) {
+  var data struct {
+    Id  int   `json:"id"`
+  }
+  err := GenPOSTJSON(issue.github, issue.ApiURL() + "/comments", &data, &struct { Body string `json:"body"` }{ text })
+  return data.Id, err
 }
 
-func (issue *Issue) UpdateTitle(newtitle string) {
-  GenPATCHJSON(issue.github, issue.ApiURL(), &struct { Title string `json:"title"` }{ newtitle })
+func (issue *Issue) DelComment(commentid int) error {
+  return GenDEL(issue.github, "repos/" + issue.RepoId + "/issues/comments/" + strconv.Itoa(commentid))
 }
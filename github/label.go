@@ -0,0 +1,92 @@
+/* Keeping the labels we rely on in the repositories */
+package github
+
+import (
+  "net/url"
+  "strings"
+  . "github.com/ErintLabs/trellohub/genapi"
+)
+
+/* A label as we want it to be, Color is hex without # */
+type LabelSpec struct {
+  Name        string    `json:"name"`
+  Color       string    `json:"color"`
+  Description string    `json:"description"`
+}
+
+/* What's wrong with a label or what was done about it */
+type LabelCheck struct {
+  Name    string  `json:"name"`
+  Status  string  `json:"status"` // ok, missing, renamed, changed
+  Actual  string  `json:"actual,omitempty"` // the name it has now if renamed
+  Fixed   bool    `json:"fixed"`
+}
+
+func (github *GitHub) repoLabels(repoid string) ([]LabelSpec, error) {
+  var labels []LabelSpec
+  err := GenGET(github, "repos/" + repoid + "/labels?per_page=100", &labels)
+  return labels, err
+}
+
+/* Gives the label currently called actual the name, the colour and the description from the spec */
+func (github *GitHub) RestoreLabel(repoid string, actual string, spec LabelSpec) error {
+  Infof("Restoring label %s (now %s) in %s.", spec.Name, actual, repoid)
+  return GenPATCHJSON(github, "repos/" + repoid + "/labels/" + url.PathEscape(actual), &struct {
+    NewName     string  `json:"new_name"`
+    Color       string  `json:"color"`
+    Description string  `json:"description"`
+  }{ spec.Name, spec.Color, spec.Description })
+}
+
+/* Compares the labels of the repository with the specs, and if fix is set makes them match.
+   A label with the colour and the description of a missing one is taken for renamed and gets its name back,
+   so that the issues keep it */
+func (github *GitHub) EnsureLabels(repoid string, specs []LabelSpec, fix bool) ([]LabelCheck, error) {
+  labels, err := github.repoLabels(repoid)
+  if err != nil {
+    return nil, err
+  }
+
+  byName := make(map[string]LabelSpec)
+  for _, v := range labels {
+    byName[strings.ToLower(v.Name)] = v
+  }
+  wanted := make(map[string]bool)
+  for _, v := range specs {
+    wanted[strings.ToLower(v.Name)] = true
+  }
+
+  res := make([]LabelCheck, 0, len(specs))
+  for _, spec := range specs {
+    check := LabelCheck{ Name: spec.Name, Status: "ok" }
+    current, found := byName[strings.ToLower(spec.Name)]
+
+    if !found {
+      check.Status = "missing"
+      for _, v := range labels {
+        if !wanted[strings.ToLower(v.Name)] && strings.EqualFold(v.Color, spec.Color) && v.Description == spec.Description && len(spec.Description) > 0 {
+          check.Status, check.Actual = "renamed", v.Name
+          break
+        }
+      }
+    } else if !strings.EqualFold(current.Color, spec.Color) || current.Description != spec.Description {
+      check.Status, check.Actual = "changed", current.Name
+    }
+
+    if fix && check.Status != "ok" {
+      var err error
+      if check.Status == "missing" {
+        Infof("Creating label %s in %s.", spec.Name, repoid)
+        err = GenPOSTJSON(github, "repos/" + repoid + "/labels", nil, &spec)
+      } else {
+        err = github.RestoreLabel(repoid, check.Actual, spec)
+      }
+      if err != nil {
+        return res, err
+      }
+      check.Fixed = true
+    }
+    res = append(res, check)
+  }
+  return res, nil
+}
@@ -3,11 +3,12 @@ package github
 
 import (
   . "github.com/ErintLabs/trellohub/genapi"
-  "log"
 )
 
 type Label struct {
-  Name      string    `json:"name"`
+  Name        string    `json:"name"`
+  Color       string    `json:"color"`
+  Description string    `json:"description"`
 }
 
 type GitUser  struct {
@@ -33,16 +34,16 @@ func (issue *Issue) SetMembers(mbmrs []GitUser) {
 /* TODO: maybe unify this all over after all */
 
 /* Adds a label to the issue */
-func (issue *Issue) AddLabel(label string) {
-  log.Printf("Adding label %s to %s", label, issue.String())
+func (issue *Issue) AddLabel(label string) error {
+  Infof("Adding label %s to %s", label, issue.String())
   lbls := [...]string { label }
-  GenPOSTJSON(issue.github, issue.ApiURL() + "/labels", nil, &lbls)
+  return GenPOSTJSON(issue.github, issue.ApiURL() + "/labels", nil, &lbls)
 }
 
 /* Removes a label from the issue */
-func (issue *Issue) DelLabel(label string) {
-  log.Printf("Removing label %s from %s", label, issue.String())
-  GenDEL(issue.github, issue.ApiURL() + "/labels/" + label) // TODO ensure 403/404 doesn't crash us
+func (issue *Issue) DelLabel(label string) error {
+  Infof("Removing label %s from %s", label, issue.String())
+  return GenDEL(issue.github, issue.ApiURL() + "/labels/" + label) // TODO ensure 403/404 doesn't crash us
 }
 
 /* Adds a user to the issue */
@@ -50,15 +51,15 @@ type userAssignRequest struct {
   Assigs  []string `json:"assignees"`
 }
 
-func (issue *Issue) AddUser(user string) {
-  log.Printf("Adding user %s to %s", user, issue.String())
+func (issue *Issue) AddUser(user string) error {
+  Infof("Adding user %s to %s", user, issue.String())
   payload := userAssignRequest{ []string{ user } }
-  GenPOSTJSON(issue.github, issue.ApiURL() + "/assignees", nil, &payload)
+  return GenPOSTJSON(issue.github, issue.ApiURL() + "/assignees", nil, &payload)
 }
 
 /* Removes a use from the issue */
-func (issue *Issue) DelUser(user string) {
-  log.Printf("Removing user %s from %s", user, issue.String())
+func (issue *Issue) DelUser(user string) error {
+  Infof("Removing user %s from %s", user, issue.String())
   payload := userAssignRequest{ []string{ user } }
-  GenDELJSON(issue.github, issue.ApiURL() + "/assignees", &payload)
+  return GenDELJSON(issue.github, issue.ApiURL() + "/assignees", &payload)
 }
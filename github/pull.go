@@ -4,57 +4,120 @@ package github
 import (
  . "github.com/ErintLabs/trellohub/genapi"
  "strconv"
+ "strings"
  "regexp"
 )
 
-type Pull Issue
+type Branch struct {
+  Ref     string    `json:"ref"`
+  Sha     string    `json:"sha"`
+}
+
+/* PRs are issues, but with branches */
+type Pull struct {
+  Issue
+  Merged  bool      `json:"merged"`
+  Base    Branch    `json:"base"`
+  Head    Branch    `json:"head"`
+}
+
+/* Keywords that close issues, same as GitHub's own */
+var DefaultCloseKeywords = []string { "close", "closes", "closed", "fix", "fixes", "fixed", "resolve", "resolves", "resolved" }
+/* Keywords that only mention them */
+var DefaultMentionKeywords = []string { "ref", "refs", "references", "see", "part of", "related to", "wip" }
+
+/* Builds the regexp matching any of keywords followed by an issue reference, nil if there are none */
+func keywordRegexp(keywords []string) *regexp.Regexp {
+  quoted := make([]string, 0, len(keywords))
+  for _, v := range keywords {
+    if v = strings.TrimSpace(v); len(v) > 0 {
+      quoted = append(quoted, strings.Replace(regexp.QuoteMeta(v), " ", "[[:space:]]+", -1))
+    }
+  }
+
+  if len(quoted) == 0 {
+    return nil
+  }
+  return regexp.MustCompile("(?i)(?:^|[^[:alnum:]_])(?:" + strings.Join(quoted, "|") + ")[[:space:]:]*" + REGEX_GH_ISSUEREF)
+}
+
+/* Sets the keywords to look for in commit messages */
+func (github *GitHub) SetKeywords(closing []string, mentions []string) {
+  github.closeRe = keywordRegexp(closing)
+  github.mentionRe = keywordRegexp(mentions)
+}
+
+/* Splits a single reference matched by REGEX_GH_ISSUEREF into the repository and the number */
+func issueRefSpec(ref string, repoid string) (string, int, bool) {
+  re := regexp.MustCompile(REGEX_GH_ISSUE)
+  if res := re.FindStringSubmatch(ref); res != nil {
+    issueno, err := strconv.Atoi(res[2])
+    return res[1], issueno, err == nil
+  }
+
+  /* Check if there was a repo specification before # */
+  repo, number := repoid, ""
+  if i := strings.LastIndex(ref, "#"); i >= 0 {
+    if i > 0 {
+      repo = ref[:i]
+    }
+    number = ref[i+1:]
+  } else if strings.HasPrefix(strings.ToLower(ref), "gh-") {
+    number = ref[3:]
+  }
+
+  issueno, err := strconv.Atoi(number)
+  return repo, issueno, err == nil
+}
 
-func (github *GitHub) extractIssueIds(message string, repoid string) []*Issue {
+/* Resolves a single reference, nil if it isn't one after all */
+func (github *GitHub) parseIssueRef(ref string, repoid string) *Issue {
+  if repo, issueno, ok := issueRefSpec(ref, repoid); ok {
+    return github.GetIssue(repo, issueno)
+  }
+  Warnf("Can't make an issue out of %s", ref)
+  return nil
+}
+
+func (github *GitHub) findIssueRefs(re *regexp.Regexp, message string, repoid string) []*Issue {
   res := make([]*Issue, 0)
+  if re == nil {
+    return res
+  }
 
   /* Assuming no single commit can close more than 256 issues okay */
-  re := regexp.MustCompile(REGEX_GH_MAGIC)
   if catch := re.FindAllStringSubmatch(message, 256); catch != nil {
     for _, v := range catch {
-      /* Check if there was a repo specification before # */
-      var repo string
-      if len(v[1]) > 0 {
-        repo = v[1]
-      } else {
-        repo = repoid
-      }
-
       /* Add the new cath */
-      iid, _ := strconv.Atoi(v[2])
-      res = append(res, github.GetIssue(repo, iid))
+      if issue := github.parseIssueRef(v[1], repoid); issue != nil {
+        res = append(res, issue)
+      }
     }
   }
 
   return res
 }
 
+/* Finds issues referenced in the message, closed ones and only mentioned ones */
+func (github *GitHub) extractIssueIds(message string, repoid string) (closing []*Issue, mentions []*Issue) {
+  return github.findIssueRefs(github.closeRe, message, repoid), github.findIssueRefs(github.mentionRe, message, repoid)
+}
 
 /* List ids of issues affected by a PR */
-func (pull *Pull) AffectedIssues() []*Issue {
-  res := make([]*Issue, 0)
-
+func (pull *Pull) AffectedIssues() []*Reference {
   /* Fetching commit data for the PR */
   var commits []GitCommit
   GenGET(pull.github, pull.ApiURL() + "/commits", &commits)
+  /* Description counts as well, it's the only one without a sha */
+  commits = append(commits, GitCommit{ Commit: Commit{ Message: pull.Body } })
 
   /* Parsing messages and finding relevant issues */
-  for _, v := range commits {
-    if issues := pull.github.extractIssueIds(v.Commit.Message, pull.RepoId); len(issues) > 0 {
-      res = append(res, issues...)
-    }
-  }
-
-  return res
+  return pull.github.collectRefs(commits, pull.RepoId)
 }
 
 /* Requests a reference to the pr */
 func (github *GitHub) GetPull(repoid string, issueno int) *Pull {
-  res := &Pull{ RepoId: repoid, IssueNo: issueno}
+  res := &Pull{ Issue: Issue{ RepoId: repoid, IssueNo: issueno } }
   if pull := github.pullBySpec[res.String()]; pull != nil {
     return pull
   } else {
@@ -67,6 +130,12 @@ func (github *GitHub) GetPull(repoid string, issueno int) *Pull {
   }
 }
 
+/* Takes the state from the event, the cached one might be stale by now */
+func (pull *Pull) Refresh(data *Pull) {
+  pull.URL, pull.Title, pull.Body = data.URL, data.Title, data.Body
+  pull.Merged, pull.Base, pull.Head = data.Merged, data.Base, data.Head
+}
+
 /* Auto-converions to string */
 // TODO maybe an interface w/ Issue? or merge into one class
 func (pull *Pull) genconv(middlepart string) string {
@@ -0,0 +1,110 @@
+package github
+
+import (
+  "reflect"
+  "regexp"
+  "testing"
+  . "github.com/ErintLabs/trellohub/genapi"
+)
+
+/* References caught by the keywords, in the order they come */
+func refs(re *regexp.Regexp, message string) []string {
+  res := make([]string, 0)
+  if re == nil {
+    return res
+  }
+  for _, v := range re.FindAllStringSubmatch(message, -1) {
+    res = append(res, v[1])
+  }
+  return res
+}
+
+func TestKeywordRegexp(t *testing.T) {
+  closing := keywordRegexp(DefaultCloseKeywords)
+  mentions := keywordRegexp(DefaultMentionKeywords)
+
+  cases := []struct {
+    message   string
+    closing   []string
+    mentions  []string
+  }{
+    { "Fix #12", []string{ "#12" }, []string{} },
+    { "fixes: #12 and closes owner/repo#3", []string{ "#12", "owner/repo#3" }, []string{} },
+    { "Resolved GH-7", []string{ "GH-7" }, []string{} },
+    { "closes https://github.com/owner/repo/issues/42", []string{ "https://github.com/owner/repo/issues/42" }, []string{} },
+    { "refs #5, part of  #6", []string{}, []string{ "#5", "#6" } },
+    { "Part\nof #6", []string{}, []string{ "#6" } },
+    { "prefixes #12", []string{}, []string{} },
+    { "bugfix #12", []string{}, []string{} },
+    { "See the fix in #12", []string{}, []string{} },
+    { "wip #1, fix #2", []string{ "#2" }, []string{ "#1" } },
+  }
+
+  for _, c := range cases {
+    if got := refs(closing, c.message); !reflect.DeepEqual(got, c.closing) {
+      t.Errorf("closing in %q: got %v, want %v", c.message, got, c.closing)
+    }
+    if got := refs(mentions, c.message); !reflect.DeepEqual(got, c.mentions) {
+      t.Errorf("mentions in %q: got %v, want %v", c.message, got, c.mentions)
+    }
+  }
+}
+
+func TestKeywordRegexpEmpty(t *testing.T) {
+  if re := keywordRegexp([]string{ "", "  " }); re != nil {
+    t.Errorf("expected no regexp without keywords, got %s", re)
+  }
+  if got := refs(keywordRegexp([]string{ "Done in" }), "done   in #4"); !reflect.DeepEqual(got, []string{ "#4" }) {
+    t.Errorf("custom keyword: got %v", got)
+  }
+}
+
+func TestIssueRef(t *testing.T) {
+  re := regexp.MustCompile("^" + REGEX_GH_ISSUEREF + "$")
+  cases := []struct {
+    ref   string
+    ok    bool
+  }{
+    { "#12", true },
+    { "owner/repo#12", true },
+    { "my-org/my.repo#1", true },
+    { "gh-12", true },
+    { "github.com/owner/repo/issues/12", true },
+    { "https://github.com/owner/repo/issues/12", true },
+    { "owner/repo#", false },
+    { "owner#12", false },
+    { "https://github.com/owner/repo/pull/12", false },
+  }
+
+  for _, c := range cases {
+    if ok := re.MatchString(c.ref); ok != c.ok {
+      t.Errorf("%q: matched %v, want %v", c.ref, ok, c.ok)
+    }
+  }
+}
+
+func TestIssueRefSpec(t *testing.T) {
+  cases := []struct {
+    ref     string
+    repo    string
+    issueno int
+    ok      bool
+  }{
+    { "#12", "owner/repo", 12, true },
+    { "other/lib#3", "other/lib", 3, true },
+    { "GH-7", "owner/repo", 7, true },
+    { "gh-7", "owner/repo", 7, true },
+    { "https://github.com/octo/lib/issues/42", "octo/lib", 42, true },
+    { "https://GitHub.com/octo/lib/issues/42", "octo/lib", 42, true },
+    { "GITHUB.COM/octo/lib/issues/42", "octo/lib", 42, true },
+    { "https://github.com/octo/lib/pull/42", "", 0, false },
+    { "#", "", 0, false },
+  }
+
+  for _, c := range cases {
+    repo, issueno, ok := issueRefSpec(c.ref, "owner/repo")
+    if ok != c.ok || (ok && (repo != c.repo || issueno != c.issueno)) {
+      t.Errorf("%q: got %s#%d (%v), want %s#%d (%v)", c.ref, repo, issueno, ok, c.repo, c.issueno, c.ok)
+    }
+  }
+}
@@ -3,16 +3,28 @@ package github
 
 import (
   "regexp"
+  "strconv"
+  "strings"
   . "github.com/ErintLabs/trellohub/genapi"
 )
 
+/* GitHub doesn't send more commits than that in a push */
+const pushCommitsLimit = 20
+
 type Push struct {
   Ref     string    `json:"ref"`
   Branch  string    `json:"-"`
+  Tag     string    `json:"-"`
   Commits []Commit  `json:"commits"`
   Repo    Repo      `json:"repository"`
   Head    Commit    `json:"head_commit"`
+  Created bool      `json:"created"`
+  Deleted bool      `json:"deleted"`
+  Forced  bool      `json:"forced"`
+  Before  string    `json:"before"`
+  After   string    `json:"after"`
   github  *GitHub   `json:"-"`
+  all     []GitCommit
 }
 
 /* Sets the instance reference also parses the ref */
@@ -24,18 +36,92 @@ func (push *Push) SetGitHub(github *GitHub) {
     push.Branch = res[1]
   }
 
+  re = regexp.MustCompile(REGEX_GH_TAG)
+  if res := re.FindStringSubmatch(push.Ref); res != nil {
+    push.Tag = res[1]
+  }
+
 }
 
-/* List ids of issues affected by a PR */
-func (push *Push) AffectedIssues() []*Issue {
-  res := make([]*Issue, 0)
+/* Whether the payload has all the commits */
+func (push *Push) truncated() bool {
+  /* New branches have nothing to compare with */
+  return len(push.Commits) >= pushCommitsLimit && !push.Created && !push.Deleted &&
+    strings.Trim(push.Before, "0") != ""
+}
 
-  /* Parsing messages and finding relevant issues */
+/* All commits of the push, fetched only once */
+func (push *Push) commits() ([]GitCommit, error) {
+  if push.all != nil {
+    return push.all, nil
+  }
+
+  all := make([]GitCommit, 0, len(push.Commits) + 1)
   for _, v := range append(push.Commits, push.Head) {
-    if issues := push.github.extractIssueIds(v.Message, push.Repo.Spec); len(issues) > 0 {
-      res = append(res, issues...)
+    all = append(all, GitCommit{ Sha: v.Id, Commit: v })
+  }
+
+  /* Large pushes need to be fetched, duplicates are dropped later */
+  if push.truncated() {
+    Infof("Push to %s has %d or more commits, fetching the rest.", push.Ref, pushCommitsLimit)
+    rest, err := push.github.Compare(push.Repo.Spec, push.Before, push.After)
+    if err != nil {
+      return nil, err
+    }
+    all = append(all, rest...)
+  }
+
+  push.all = all
+  return push.all, nil
+}
+
+/* List ids of issues affected by a push */
+func (push *Push) AffectedIssues() ([]*Reference, error) {
+  commits, err := push.commits()
+  if err != nil {
+    return nil, err
+  }
+  /* Parsing messages and finding relevant issues */
+  return push.github.collectRefs(commits, push.Repo.Spec), nil
+}
+
+/* Lists reverts in the push */
+func (push *Push) Reverts() ([]*Revert, error) {
+  commits, err := push.commits()
+  if err != nil {
+    return nil, err
+  }
+  return push.github.collectReverts(commits, push.Repo.Spec), nil
+}
+
+/* Finds the issue a feature branch is named after, nil if the name doesn't match the pattern or there is no such issue */
+func (push *Push) BranchIssue(pattern string) *Issue {
+  re := regexp.MustCompile(pattern)
+  if len(push.Branch) > 0 {
+    if res := re.FindStringSubmatch(push.Branch); res != nil {
+      for _, v := range res[1:] {
+        if len(v) == 0 {
+          continue
+        }
+        issueno, _ := strconv.Atoi(v)
+        issue, err := push.github.LookupIssue(push.Repo.Spec, issueno)
+        if err != nil {
+          Infof("Branch %s looks like issue #%d, but there is none: %s", push.Branch, issueno, err)
+        }
+        return issue
+      }
     }
   }
 
-  return res
+  return nil
+}
+
+/* Link to the branch compared to the default one */
+func (push *Push) BranchURL() string {
+  return "https://github.com/" + push.Repo.Spec + "/compare/" + push.Branch
+}
+
+/* Release page of the pushed tag */
+func (push *Push) ReleaseURL() string {
+  return "https://github.com/" + push.Repo.Spec + "/releases/tag/" + push.Tag
 }
@@ -0,0 +1,74 @@
+/* Operations with GitHub releases and tags */
+package github
+
+import (
+  . "github.com/ErintLabs/trellohub/genapi"
+  "net/url"
+  "strconv"
+)
+
+type Release struct {
+  Tag     string    `json:"tag_name"`
+  URL     string    `json:"html_url"`
+}
+
+type tag struct {
+  Name    string    `json:"name"`
+  Commit  struct {
+    Sha   string    `json:"sha"`
+  }                 `json:"commit"`
+}
+
+/* How far back in the history we look for the previous tag, in pages of 100 commits */
+const previousTagPages = 10
+
+/* Names of the tags by the commit they point at, all pages */
+func (github *GitHub) tagsByCommit(repoid string) (map[string]string, error) {
+  res := make(map[string]string)
+  for page := 1; ; page++ {
+    var tags []tag
+    if err := GenGET(github, "repos/" + repoid + "/tags?per_page=100&page=" + strconv.Itoa(page), &tags); err != nil {
+      return nil, err
+    }
+    for _, v := range tags {
+      res[v.Commit.Sha] = v.Name
+    }
+    if len(tags) < 100 {
+      return res, nil
+    }
+  }
+}
+
+/* Finds the nearest tag in the history of the given one, empty string if it's the first.
+   GitHub lists tags by name rather than by date (v1.10 comes before v1.2), so the history is walked instead */
+func (github *GitHub) PreviousTag(repoid string, name string) (string, error) {
+  tags, err := github.tagsByCommit(repoid)
+  if err != nil {
+    return "", err
+  }
+
+  for page := 1; page <= previousTagPages; page++ {
+    var commits []GitCommit
+    if err := GenGET(github, "repos/" + repoid + "/commits?sha=" + url.QueryEscape(name) +
+      "&per_page=100&page=" + strconv.Itoa(page), &commits); err != nil {
+      return "", err
+    }
+
+    for i, v := range commits {
+      /* The first one is the tagged commit itself */
+      if page == 1 && i == 0 {
+        continue
+      }
+      if prev := tags[v.Sha]; len(prev) > 0 {
+        return prev, nil
+      }
+    }
+
+    if len(commits) < 100 {
+      return "", nil
+    }
+  }
+
+  Warnf("No tag within %d commits before %s in %s.", previousTagPages * 100, name, repoid)
+  return "", nil
+}
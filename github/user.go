@@ -0,0 +1,46 @@
+/* Operations with GitHub users */
+package github
+
+import (
+  . "github.com/ErintLabs/trellohub/genapi"
+  "strconv"
+)
+
+type GitProfile struct {
+  Login     string    `json:"login"`
+  Name      string    `json:"name"`
+}
+
+/* Lists people with access to the repository, along with their full names */
+func (github *GitHub) Collaborators(repoid string) ([]GitProfile, error) {
+  res := make([]GitProfile, 0)
+  for page := 1; ; page++ {
+    var users []GitUser
+    if err := GenGET(github, "repos/" + repoid + "/collaborators?per_page=100&page=" + strconv.Itoa(page), &users); err != nil {
+      return res, err
+    }
+    for _, v := range users {
+      res = append(res, github.profile(v.Name))
+    }
+    if len(users) < 100 {
+      return res, nil
+    }
+  }
+}
+
+/* Names are only in the profile, which is read once per user */
+func (github *GitHub) profile(login string) GitProfile {
+  if profile, ok := github.profiles[login]; ok {
+    return profile
+  }
+
+  var profile GitProfile
+  if err := GenGET(github, "users/" + login, &profile); err != nil {
+    return GitProfile{ Login: login } // next time then
+  }
+  if len(profile.Login) == 0 {
+    profile.Login = login
+  }
+  github.profiles[login] = profile
+  return profile
+}
@@ -2,13 +2,14 @@ package main
 
 import (
     "fmt"
-    "log"
     "net/http"
     "os"
     "io/ioutil"
     "encoding/json"
     "regexp"
+    "strings"
     "sync"
+    "time"
     . "github.com/ErintLabs/trellohub/genapi"
     "github.com/ErintLabs/trellohub/trello"
     "github.com/ErintLabs/trellohub/github"
@@ -27,6 +28,20 @@ var config struct {
   StableBranch    string
   TestBranch      string
   UnstableBranch  string
+  ApprovedList    string
+  BranchPattern   string
+  DeployLists     map[string]string
+  ReleaseList     string
+  RevertList      string
+  CloseKeywords   []string
+  MentionKeywords []string
+  UserStore       string
+  MemberRemoval   string
+  AdminToken      string
+  ShutdownTimeout time.Duration
+  PendingFile     string
+  StateStore      string
+  RecordDir       string
 }
 
 var cache struct {
@@ -34,154 +49,302 @@ var cache struct {
   ListIdByGitLabel    map[string]string
   GitHubUserByTrello  map[string]string
   TrelloUserByGitHub  map[string]string
+  SkippedMoves        map[string]string
+  Moves               map[string]*moveCause
+  SyncReports         map[string]*syncReport
+  Events              []eventState
+  UnmappedUsers       Set
   mutex               sync.Mutex
 }
 
 func GetEnv(varname string) string {
   res := os.Getenv(varname)
   if len(res) <= 0 {
-    log.Fatalf("$%s must be set.", varname)
+    Fatalf("$%s must be set.", varname)
   }
 
   return res
 }
 
+/* Same as above but for optional settings */
+func GetEnvOpt(varname string, def string) string {
+  if res := os.Getenv(varname); len(res) > 0 {
+    return res
+  }
+
+  return def
+}
+
+/* GitHub mentions are looked up by GitHub name and vice versa */
 func g2t(str string) string {
-  return RepMentions(str, cache.GitHubUserByTrello)
+  res, unknown := TranslateMentions(str, cache.TrelloUserByGitHub)
+  reportUnmapped("GitHub", unknown)
+  return res
 }
 
 func t2g(str string) string {
-  return RepMentions(str, cache.TrelloUserByGitHub)
+  res, unknown := TranslateMentions(str, cache.GitHubUserByTrello)
+  reportUnmapped("Trello", unknown)
+  return res
 }
 
-func main() {
-  /* Check if we are run to [re]-initialise the board */
-  if (len(os.Args) >= 4) {
-    config.TrelloKey, config.TrelloToken, config.BoardId = os.Args[1], os.Args[2], os.Args[3]
-    trello_obj = trello.New(config.TrelloKey, config.TrelloToken, config.BoardId)
-
-    /* Archive all open lists */
-    for _, v := range trello_obj.GetLists() {
-      v.Close()
-    }
+/* Same for Markdown texts, which also need to be converted between the dialects */
+func g2tBody(str string, repoid string) string {
+  return GitHubToTrello(g2t(str), repoid)
+}
+
+func t2gBody(str string, repoid string) string {
+  return t2g(TrelloToGitHub(str, repoid))
+}
 
-    /* Ugly but effective, creating new lists */
-    trello_obj.Lists = trello.ListRef{
-      trello_obj.AddList("📋 Repositories"),
-      trello_obj.AddList("📥 Inbox"),
-      trello_obj.AddList("🚧 In Works"),
-      trello_obj.AddList("🚫 Blocked"),
-      trello_obj.AddList("📝 Awaiting Review"),
-      trello_obj.AddList("💾 Merged to Mainline"),
-      trello_obj.AddList("📲 Deployed on Test"),
-      trello_obj.AddList("📱 Tested"),
-      trello_obj.AddList("📤 Accepted"),
+/* Keeps track of users missing in the table, so that it can be completed */
+func reportUnmapped(side string, users []string) {
+  for _, v := range users {
+    if key := side + ":" + v; !cache.UnmappedUsers[key] {
+      cache.UnmappedUsers[key] = true
+      Warnf("%s user %s is not in the user table, leaving the mention as is.", side, v)
     }
+  }
+}
+
+func main() {
+  /* Logging goes first, so that everything else can complain properly */
+  if err := SetLogFormat(GetEnvOpt("LOG_FORMAT", "text")); err != nil {
+    Fatalf("$LOG_FORMAT: %s", err)
+  }
+  if err := SetLogLevel(GetEnvOpt("LOG_LEVEL", "info")); err != nil {
+    Fatalf("$LOG_LEVEL: %s", err)
+  }
 
-    /* Happily print the JSON */
-    data, _ := json.Marshal(trello_obj.Lists)
-    fmt.Println("Set $LISTS to the following value:")
-    fmt.Println(string(data[:]))
+  /* Check if we are run to set the board up */
+  if len(os.Args) >= 5 && os.Args[1] == "provision" {
+    provision(os.Args[2], os.Args[3], os.Args[4], len(os.Args) >= 6 && os.Args[5] == "apply")
+  } else if len(os.Args) >= 3 && os.Args[1] == "replay" {
+    /* Feeding recorded events through the handlers, see $RECORD_DIR */
+    DryRun = len(os.Args) >= 4 && os.Args[3] == "dry-run"
+    setup()
+    if DryRun {
+      /* Everything is loaded already, changes stay in memory */
+      config.UserStore, config.StateStore = "", ""
+    }
+    replay(os.Args[2])
+  } else if len(os.Args) == 4 {
+    /* The old way, used to wipe the board clean; now it only shows what provisioning would do */
+    Warnf("This form is deprecated, use: %s provision KEY TOKEN BOARD [apply]", os.Args[0])
+    provision(os.Args[1], os.Args[2], os.Args[3], false)
   } else {
-    /* Server configuration */
-    config.BaseURL, config.Port = GetEnv("URL"), GetEnv("PORT")
-
-    /* Trello config */
-    config.TrelloKey, config.TrelloToken = GetEnv("TRELLO_KEY"), GetEnv("TRELLO_TOKEN")
-    config.BoardId = GetEnv("BOARD")
-
-    /* GitHub config */
-    config.GitHubToken = GetEnv("GITHUB_TOKEN")
-    config.StableBranch, config.TestBranch, config.UnstableBranch = GetEnv("STABLE_BRANCH"), GetEnv("TEST_BRANCH"), GetEnv("UNSTABLE_BRANCH")
-
-    /* Instantiating globals */
-    trello_obj = trello.New(config.TrelloKey, config.TrelloToken, config.BoardId)
-    github_obj = github.New(config.GitHubToken)
-    go func () {
-      cache.mutex.Lock()
-      defer cache.mutex.Unlock()
-      trello_obj.Startup(github_obj)
-    }()
-
-    /* List indexes */
-    json.Unmarshal([]byte(GetEnv("LISTS")), &trello_obj.Lists)
-
-    /* Trello to GitHub correspondence, also reversing */
-    json.Unmarshal([]byte(GetEnv("USER_TABLE")), &cache.GitHubUserByTrello)
-    cache.TrelloUserByGitHub = DicRev(cache.GitHubUserByTrello)
-
-    /* Registering handlers */
-    http.HandleFunc("/trello", TrelloFunc)
-    http.HandleFunc("/trello/", TrelloFunc)
-
-    http.HandleFunc("/issues", IssuesFunc)
-    http.HandleFunc("/issues/", IssuesFunc)
-
-    http.HandleFunc("/pull", PullFunc)
-    http.HandleFunc("/pull/", PullFunc)
-
-    http.HandleFunc("/push", PushFunc)
-    http.HandleFunc("/push/", PushFunc)
-
-    /* Ensuring Trello hook */
-    /* TODO: study if this doesn't cause races */
-    // TODO: ex SIGTERM problem
-    go func () {
-      cache.mutex.Lock()
-      defer cache.mutex.Unlock()
-      trello_obj.EnsureHook(config.BaseURL + "/trello")
-    }()
-
-    /* Fill the GitHub label names cache */
-    cache.GitLabelByListId = map[string]string {
-      trello_obj.Lists.InboxId: "inbox",
-      trello_obj.Lists.InWorksId: "work",
-      trello_obj.Lists.BlockedId: "block",
-      trello_obj.Lists.ReviewId: "review",
-      trello_obj.Lists.MergedId: "merged",
-      trello_obj.Lists.DeployId: "deploy",
-      trello_obj.Lists.TestId: "test",
-      trello_obj.Lists.AcceptId: "done",
+    setup()
+    config.RecordDir = GetEnvOpt("RECORD_DIR", "")
+    if len(config.RecordDir) > 0 {
+      if err := os.MkdirAll(config.RecordDir, 0700); err != nil {
+        Fatalf("$RECORD_DIR: %s", err)
+      }
     }
-    cache.ListIdByGitLabel = DicRev(cache.GitLabelByListId)
 
-    /* Starting the server up */
-    log.Fatal(http.ListenAndServe(":"+config.Port, nil))
+    /* Warming the caches up and ensuring the hooks, see /readyz */
+    initReadiness()
+    go startup()
+
+    /* Starting the server up, until we're told to stop */
+    serve()
   }
 }
 
+/* Reads the configuration, instantiates the globals and registers the handlers */
+func setup() {
+  /* Server configuration */
+  config.BaseURL, config.Port = GetEnv("URL"), GetEnv("PORT")
+
+  /* Trello config */
+  config.TrelloKey, config.TrelloToken = GetEnv("TRELLO_KEY"), GetEnv("TRELLO_TOKEN")
+  config.BoardId = GetEnv("BOARD")
+
+  /* GitHub config */
+  config.GitHubToken = GetEnv("GITHUB_TOKEN")
+  config.StableBranch, config.UnstableBranch = GetEnv("STABLE_BRANCH"), GetEnv("UNSTABLE_BRANCH")
+  /* Test deployments can be tracked through GitHub deployments instead */
+  config.TestBranch = GetEnvOpt("TEST_BRANCH", "")
+  json.Unmarshal([]byte(GetEnvOpt("DEPLOY_LISTS", "{}")), &config.DeployLists)
+  config.ReleaseList = GetEnvOpt("RELEASE_LIST", "accept")
+  config.RevertList = GetEnvOpt("REVERT_LIST", "works")
+  config.AdminToken = GetEnvOpt("ADMIN_TOKEN", "")
+  config.CloseKeywords = strings.Split(GetEnvOpt("CLOSE_KEYWORDS", strings.Join(github.DefaultCloseKeywords, ",")), ",")
+  config.MentionKeywords = strings.Split(GetEnvOpt("MENTION_KEYWORDS", strings.Join(github.DefaultMentionKeywords, ",")), ",")
+  config.ApprovedList = GetEnvOpt("APPROVED_LIST", "")
+  config.BranchPattern = GetEnvOpt("BRANCH_PATTERN", REGEX_GH_FEATURE)
+  if _, err := regexp.Compile(config.BranchPattern); err != nil {
+    Fatalf("$BRANCH_PATTERN is not a valid regexp: %s", err)
+  }
+
+  /* Instantiating globals */
+  trello_obj = trello.New(config.TrelloKey, config.TrelloToken, config.BoardId)
+  github_obj = github.New(config.GitHubToken)
+  github_obj.SetKeywords(config.CloseKeywords, config.MentionKeywords)
+
+  /* List indexes */
+  json.Unmarshal([]byte(GetEnv("LISTS")), &trello_obj.Lists)
+
+  /* Trello to GitHub correspondence, also reversing */
+  config.UserStore = GetEnvOpt("USER_STORE", "users.json")
+  config.ShutdownTimeout = parseTimeout(GetEnvOpt("SHUTDOWN_TIMEOUT", "25s"))
+  config.PendingFile = GetEnvOpt("PENDING_FILE", "pending.jsonl")
+  config.StateStore = GetEnvOpt("STATE_STORE", "state.json")
+  loadUsers()
+  trello_obj.OnRenamed = renameUser
+  switch config.MemberRemoval = GetEnvOpt("MEMBER_REMOVAL", "keep"); config.MemberRemoval {
+  case "keep", "unassign":
+  default:
+    Fatalf("$MEMBER_REMOVAL must be either keep or unassign, not %s.", config.MemberRemoval)
+  }
+
+  /* Registering handlers */
+  http.HandleFunc("/trello", TrelloFunc)
+  http.HandleFunc("/trello/", TrelloFunc)
+
+  http.HandleFunc("/issues", IssuesFunc)
+  http.HandleFunc("/issues/", IssuesFunc)
+
+  http.HandleFunc("/pull", PullFunc)
+  http.HandleFunc("/pull/", PullFunc)
+
+  http.HandleFunc("/push", PushFunc)
+  http.HandleFunc("/push/", PushFunc)
+
+  http.HandleFunc("/review", ReviewFunc)
+  http.HandleFunc("/review/", ReviewFunc)
+
+  http.HandleFunc("/deploy", DeployFunc)
+  http.HandleFunc("/deploy/", DeployFunc)
+
+  http.HandleFunc("/release", ReleaseFunc)
+  http.HandleFunc("/release/", ReleaseFunc)
+
+  http.HandleFunc("/label", LabelFunc)
+  http.HandleFunc("/label/", LabelFunc)
+
+  http.HandleFunc("/metrics", MetricsFunc)
+  http.HandleFunc("/healthz", HealthFunc)
+  http.HandleFunc("/readyz", ReadyFunc)
+
+  /* Administration */
+  http.HandleFunc("/admin", AdminFunc)
+  http.HandleFunc("/admin/", AdminFunc)
+  http.HandleFunc("/admin/refresh", AdminRefreshFunc)
+  http.HandleFunc("/admin/users", UsersFunc)
+  http.HandleFunc("/admin/users/proposals", UserProposalsFunc)
+  http.HandleFunc("/admin/labels", AdminLabelsFunc)
+
+  /* Workflow labels as configured, the rest as declared */
+  var labels map[string]github.LabelSpec
+  if err := json.Unmarshal([]byte(GetEnvOpt("WORKFLOW_LABELS", "{}")), &labels); err != nil {
+    Fatalf("$WORKFLOW_LABELS must be a JSON object like {\"inbox\": {\"color\": \"ededed\"}}: %s", err)
+  }
+  if err := trello.ConfigureLabels(labels); err != nil {
+    Fatalf("$WORKFLOW_LABELS: %s", err)
+  }
+
+  /* Fill the GitHub label names cache */
+  cache.GitLabelByListId = make(map[string]string)
+  for _, v := range trello.Workflow {
+    if len(v.Label.Name) > 0 {
+      cache.GitLabelByListId[trello_obj.Lists.ByName(v.Key)] = v.Label.Name
+    }
+  }
+  cache.ListIdByGitLabel = DicRev(cache.GitLabelByListId)
+  cache.SkippedMoves = make(map[string]string)
+  cache.Moves = make(map[string]*moveCause)
+  cache.SyncReports = make(map[string]*syncReport)
+  cache.UnmappedUsers = NewSet()
+  loadState()
+  OnJournal = journalEvent
+}
+
 type handleSubroutine func (body []byte) (int, string)
 
 func GeneralisedProcess(w http.ResponseWriter, r *http.Request, f handleSubroutine) {
-  /* We don't care about performance, therefore enforce that only one proc can be running at a given time */
-  cache.mutex.Lock()
-  defer cache.mutex.Unlock()
+  /* Trello checks the callback with a HEAD while we are installing the hook at startup, holding the mutex */
+  if r.Method == "HEAD" {
+    fmt.Fprintln(w, "Pleased to meet you.")
+    return
+  }
 
   // TODO io.LimitReader
   // TODO check if its or POST
   body, err := ioutil.ReadAll(r.Body)
   if err != nil {
-      log.Fatal(err)
+    http.Error(w, err.Error(), http.StatusBadRequest)
+    return
+  }
+
+  /* Keeping the event around until it's done, in case we have to shut down meanwhile */
+  stored := &storedEvent{ time.Now(), r.URL.Path, r.Header, string(body) }
+  recordRaw(stored)
+  ticket, ok := track(stored)
+  if !ok {
+    http.Error(w, "Shutting down, try again later.", http.StatusServiceUnavailable)
+    return
   }
+  defer untrack(ticket)
+
+  /* We don't care about performance, therefore enforce that only one proc can be running at a given time */
+  lockCache()
+  defer cache.mutex.Unlock()
+  if abandoned() {
+    http.Error(w, "Shutting down, this one will be done after the restart.", http.StatusServiceUnavailable)
+    return
+  }
+
+  /* Everything logged from now on is about this event */
+  id, evt := describeEvent(r, body)
+  SetCorrelation(id)
+  defer SetCorrelation("")
+  /* Only report on what this event did */
+  TakeCalls()
 
-  /* Invoking the actual function */
-  //log.Print(string(body[:]))
   var code int
   var text string
+  switch status := eventStatus(id); status {
+  /* Redelivered after it was taken care of, e.g. replayed from the pending ones */
+  case eventDone:
+    code, text = http.StatusOK, "Done that already."
+  default:
+    /* Done again, but the changes it made before we went down are not repeated: no second comment or card */
+    var done []JournalEntry
+    if status == eventStarted {
+      done = eventJournal(id)
+      Warnf("This event was interrupted halfway in the previous run, finishing it, %d changes were made already.", len(done))
+    }
+    StartJournal(id, done)
 
-  if r.Method != "HEAD" {
+    /* Remembered right away, in case we go down in the middle */
+    noteEvent(id, eventStarted)
+    saveState()
+
+    /* Invoking the actual function */
+    //log.Print(string(body[:]))
     code, text = f(body)
-  } else { /* or not, if it's a HEAD */
-    code, text = http.StatusOK, "Pleased to meet you."
+    StopJournal()
+    reportSync(TakeCalls())
+    if code < 300 {
+      noteEvent(id, eventDone)
+    } else {
+      noteEvent(id, eventFailed)
+    }
+    saveState()
   }
 
+  ForEvent(id).Infof("Replied %d: %s", code, text)
+  recordEvent(r, id, evt, code, text)
+  countEvent(r, evt, code)
+  updateCacheSizes()
+
   /* Replying to the caller */
   w.WriteHeader(code)
   fmt.Fprintln(w, text)
 
   /* Finalise session */
   if err := r.Body.Close(); err != nil {
-      log.Fatal(err)
+      Fatalf("%s", err)
   }
 }
 
@@ -190,7 +353,7 @@ func TrelloFunc(w http.ResponseWriter, r *http.Request) {
     var event trello.Payload
     json.Unmarshal(body, &event)
     evt := event.Action.Type
-    log.Printf("[Trello] %s", evt)
+    Infof("Trello event %s", evt)
 
     /* Determining which action happened */
     switch (evt) {
@@ -202,17 +365,29 @@ func TrelloFunc(w http.ResponseWriter, r *http.Request) {
         re := regexp.MustCompile(REGEX_GH_REPO)
         if res := re.FindStringSubmatch(event.Action.Data.Attach.URL); res != nil {
           repoid := res[1]
-          log.Printf("Registering new repository: %s.", repoid)
+          Infof("Registering new repository: %s.", repoid)
 
           /* Add a label, but make sure no duplicates happen */
           if trello_obj.GetLabel(repoid) == "" {
             card.SetLabel(trello_obj.AddLabel(repoid))
           } else {
-            log.Print("Label already there, not proceeding.")
+            Infof("Label already there, not proceeding.")
           }
 
           /* Installing webhooks if necessary */
           github_obj.EnsureHook(repoid, config.BaseURL)
+          /* Workflow labels have to be there before the first issue gets one */
+          if _, err := github_obj.EnsureLabels(repoid, trello.WorkflowLabels(), true); err != nil {
+            Errorf("Can't set up the workflow labels in %s: %s", repoid, err)
+          }
+        }
+      } else if card.Issue != nil && !regexp.MustCompile(REGEX_GH_HOSTED).MatchString(event.Action.Data.Attach.URL) {
+        /* Files added on Trello are listed in the issue, GitHub links are ours or already there */
+        if card.Issue.AddAttachment(event.Action.Data.Attach.Name, event.Action.Data.Attach.URL) {
+          newbody := renderBody(card)
+          card.Issue.UpdateBody(newbody)
+          // TODO: remove when #32 is fixed
+          card.Issue.Newbody = newbody
         }
       } // TODO do we want to dance with other types of card attachments? e.g. somebody manually adds an issue link
       return http.StatusOK, "Attachment processed."
@@ -242,12 +417,10 @@ func TrelloFunc(w http.ResponseWriter, r *http.Request) {
       if event.Action.Data.Card.Desc != event.Action.Data.Old.Desc {
         card.Desc = event.Action.Data.Card.Desc
         /* Compare to the save one and regenerate if needed */
-        if card.Issue != nil && g2t(card.Issue.Body) != card.Desc {
-          newbody := card.Desc
-          if card.Checklist != nil {
-            newbody = newbody + card.Checklist.Render()
-          }
-          card.Issue.UpdateBody(t2g(newbody))
+        if card.Issue != nil && g2tBody(card.Issue.Body, card.Issue.RepoId) != card.Desc {
+          card.Issue.UpdateBody(renderBody(card))
+        } else if card.Issue != nil {
+          loopSuppressed.Inc("description")
         }
       }
       /* If name changed */
@@ -256,6 +429,8 @@ func TrelloFunc(w http.ResponseWriter, r *http.Request) {
         /* Compare to the save one and update if needed */
         if card.Issue != nil && g2t(card.Issue.Title) != card.Name {
           card.Issue.UpdateTitle(t2g(card.Name))
+        } else if card.Issue != nil {
+          loopSuppressed.Inc("title")
         }
       }
       return http.StatusOK, "Card update processed."
@@ -280,6 +455,7 @@ func TrelloFunc(w http.ResponseWriter, r *http.Request) {
             issue.DelUser(guser)
             return http.StatusOK, "User removed."
           }
+          loopSuppressed.Inc("member")
         } else {
           return http.StatusOK, "No issue to the card, call the cops, I don't care."
         }
@@ -287,6 +463,39 @@ func TrelloFunc(w http.ResponseWriter, r *http.Request) {
         return http.StatusNotFound, "Sorry I have no idea who that user is."
       }
 
+    case "addMemberToBoard":
+      userid := event.Action.Data.Added
+      if len(userid) == 0 {
+        userid = event.Action.Member.Id
+      }
+      trello_obj.AddMember(userid, event.Action.Member.Name, event.Action.Member.FullName)
+      return http.StatusOK, "Welcome aboard."
+
+    case "removeMemberFromBoard":
+      userid := event.Action.Data.Added
+      if len(userid) == 0 {
+        userid = event.Action.Member.Id
+      }
+
+      /* Trello takes them off the cards itself, but whether issues follow is up to the policy */
+      cards := trello_obj.CardsWithMember(userid)
+      tuser := trello_obj.RemoveMember(userid)
+      for _, card := range cards {
+        card.Members[userid] = false
+        if issue := card.Issue; issue != nil && config.MemberRemoval == "unassign" {
+          if guser := cache.GitHubUserByTrello[tuser]; len(guser) > 0 && issue.Members[guser] {
+            issue.DelUser(guser)
+          }
+        }
+      }
+      return http.StatusOK, "Farewell."
+
+    case "addLabelToCard", "removeLabelFromCard":
+      /* Only the cache needs to know, labels of the cards don't go to GitHub */
+      card := trello_obj.GetCard(event.Action.Data.Card.Id)
+      card.NoteLabel(event.Action.Data.Label.Id, evt[0] == 'a')
+      return http.StatusOK, "Label noted."
+
     case "addChecklistToCard", "createCheckItem",
       "updateCheckItemStateOnCard", "updateCheckItem",
       "deleteCheckItem", "removeChecklistFromCard":
@@ -311,7 +520,7 @@ func TrelloFunc(w http.ResponseWriter, r *http.Request) {
       switch (evt) {
       case "updateCheckItemStateOnCard", "deleteCheckItem":
         if card.Issue.Checklist == nil || card.Checklist == nil {
-          log.Printf("[ERROR] Operating on nil checklist. issue.chlist = %#v card.chlist = %#v", card.Issue.Checklist, card.Checklist)
+          Errorf("Operating on nil checklist. issue.chlist = %#v card.chlist = %#v", card.Issue.Checklist, card.Checklist)
           return http.StatusInternalServerError, "Nil checklist encountered"
         }
       }
@@ -340,7 +549,7 @@ func TrelloFunc(w http.ResponseWriter, r *http.Request) {
         card.Checklist.Items[no].Checked = check
       case "updateCheckItem":
         no := card.Checklist.At(event.Action.Data.ChItem.Id)
-        if card.Issue.Checklist[no].Text == t2g(event.Action.Data.ChItem.Text) {
+        if card.Issue.Checklist[no].Text == t2gBody(event.Action.Data.ChItem.Text, card.Issue.RepoId) {
           needsUpdate = false
         }
         card.Checklist.Items[no].Text = event.Action.Data.ChItem.Text
@@ -358,13 +567,10 @@ func TrelloFunc(w http.ResponseWriter, r *http.Request) {
       }
       if needsUpdate {
         /* Regenerate the new issue body and update it */
-        newbody := card.Desc
-        if card.Checklist != nil { /* We may have deleted the checklist */
-          newbody = newbody + card.Checklist.Render()
-        }
-        card.Issue.UpdateBody(t2g(newbody))
+        newbody := renderBody(card)
+        card.Issue.UpdateBody(newbody)
         // TODO: remove when #32 is fixed
-        card.Issue.Newbody = t2g(newbody)
+        card.Issue.Newbody = newbody
       }
       return http.StatusOK, "Checklists updated"
 
@@ -383,7 +589,7 @@ func IssuesFunc(w http.ResponseWriter, r *http.Request) {
     /* TODO check whether we serve this repo */
     var payload github.Payload
     json.Unmarshal(body, &payload)
-    log.Printf("[Github Issues] %s", payload.Action)
+    Infof("GitHub issues event %s", payload.Action)
 
     /* Guess we have a new issue */
     switch (payload.Action) {
@@ -397,17 +603,19 @@ func IssuesFunc(w http.ResponseWriter, r *http.Request) {
         // TODO: remove when #32 is fixed
         if payload.Changes.Body.From == payload.Issue.Body && len(issue.Newbody) > 0 {
           /* Aww crappity! */
-          log.Printf("[BUG] Server sent us nonsense payload, using in-house data.")
+          Errorf("Server sent us nonsense payload, using in-house data.")
+          loopSuppressed.Inc("body")
           issue.Body = issue.Newbody
           issue.Newbody = payload.Issue.Body // just in case
         } else {
           issue.Body = payload.Issue.Body
         }
+        issue.GenAttachments()
         issue.GenChecklist()
 
         /* Shortcuts */
         trello_title := g2t(issue.Title)
-        trello_descr := g2t(issue.Body)
+        trello_descr := g2tBody(issue.Body, issue.RepoId)
         var card *trello.Card
 
         if payload.Action == "opened" {
@@ -416,7 +624,9 @@ func IssuesFunc(w http.ResponseWriter, r *http.Request) {
           card.AttachIssue(issue)
           card.SetLabel(labelid)
 
-          issue.AddLabel("inbox")
+          if label := cache.GitLabelByListId[trello_obj.Lists.InboxId]; len(label) > 0 {
+            issue.AddLabel(label)
+          }
           issue.SetLabels(payload.Issue.LabelsDb)
           issue.SetMembers(payload.Issue.Assigs)
           for k, v := range issue.Members {
@@ -426,7 +636,7 @@ func IssuesFunc(w http.ResponseWriter, r *http.Request) {
           }
 
           /* Happily report */
-          log.Printf("Creating card %s for issue %s\n", card.Id, issue.String())
+          Infof("Creating card %s for issue %s\n", card.Id, issue.String())
         } else if payload.Action == "edited" {
           if card = trello_obj.FindCard(issue.String()); card != nil {
             /* Post updates to whichever attribute changed */
@@ -441,12 +651,15 @@ func IssuesFunc(w http.ResponseWriter, r *http.Request) {
           }
         }
 
+        /* Screenshots and files go to the card as well */
+        card.AttachOnce(issue.Uploads()...)
+
         /* If issue is just opened or if it's an edit that might potentially add a checklist, try forming it */
         if payload.Action == "opened" || card.Checklist == nil {
           if len(issue.Checklist) > 0 {
             checklist := card.AddChecklist()
             for _, v := range issue.Checklist {
-              checklist.PostToChecklist(CheckItem{ Text: g2t(v.Text) , Checked: v.Checked })
+              checklist.PostToChecklist(CheckItem{ Text: g2tBody(v.Text, issue.RepoId) , Checked: v.Checked })
             }
           }
         } else if payload.Action == "edited" { /* Update the list */
@@ -460,7 +673,7 @@ func IssuesFunc(w http.ResponseWriter, r *http.Request) {
               if i >= len(card.Checklist.Items) {
                 card.Checklist.PostToChecklist(v)
               } else { /* Otherwise post updates */
-                if gtext := g2t(v.Text); gtext != card.Checklist.Items[i].Text {
+                if gtext := g2tBody(v.Text, issue.RepoId); gtext != card.Checklist.Items[i].Text {
                   card.Checklist.UpdateItemName(i, gtext)
                 }
                 if v.Checked != card.Checklist.Items[i].Checked {
@@ -493,6 +706,7 @@ func IssuesFunc(w http.ResponseWriter, r *http.Request) {
           card.Move(listid)
           return http.StatusOK, "Understood, moving card."
         } else {
+          loopSuppressed.Inc("label")
           return http.StatusOK, "The card was already there but thank you."
         }
       } else if card == nil {
@@ -520,6 +734,7 @@ func IssuesFunc(w http.ResponseWriter, r *http.Request) {
           }
           return http.StatusOK, "Card users updated."
         } else {
+          loopSuppressed.Inc("assignee")
           return http.StatusOK, "Well I already know this anyway."
         }
       /* Something's wrong */
@@ -543,25 +758,39 @@ func PullFunc(w http.ResponseWriter, r *http.Request) {
     /* TODO check whether we serve this repo */
     var payload github.Payload
     json.Unmarshal(body, &payload)
-    log.Printf("[Github PRs] %s", payload.Action)
+    Infof("GitHub pull request event %s", payload.Action)
 
     switch (payload.Action) {
-      case "opened", "synchronize":
+      /* Asking for a review again puts the cards back as well, edits might reference new ones */
+      case "opened", "synchronize", "edited", "review_requested":
       /* Look up the corresponding trello label */
       if labelid := trello_obj.GetLabel(payload.Repo.Spec); len(labelid) > 0 {
         /* Generating an in-DB refernce and updating it */
         pull := github_obj.GetPull(payload.Repo.Spec, payload.Pull.IssueNo)
+        pull.Refresh(&payload.Pull)
 
         /* For each issue try to move to Review list if it's not there already */
-        for _, v := range pull.AffectedIssues() {
-          if card := trello_obj.FindCard(v.String()); card != nil {
-            if card.ListId != trello_obj.Lists.ReviewId {
-              card.Move(trello_obj.Lists.ReviewId)
-            }
-          } else {
-            log.Printf("Can't find the card for issue %s", v.String())
-            return http.StatusNotFound, "No card found for the issue"
-          }
+        return movePullIssues(pull, trello_obj.Lists.ReviewId, false, payload.Action == "synchronize")
+      }
+
+    case "closed", "reopened":
+      if labelid := trello_obj.GetLabel(payload.Repo.Spec); len(labelid) > 0 {
+        pull := github_obj.GetPull(payload.Repo.Spec, payload.Pull.IssueNo)
+        pull.Refresh(&payload.Pull)
+
+        /* Merged ones go wherever the base branch says, abandoned ones go back to work */
+        var listid string
+        backward := false
+        if payload.Action == "reopened" {
+          listid = trello_obj.Lists.ReviewId
+        } else if payload.Pull.Merged {
+          listid = listByBranch(payload.Pull.Base.Ref)
+        } else {
+          listid, backward = trello_obj.Lists.InWorksId, true
+        }
+
+        if len(listid) > 0 {
+          return movePullIssues(pull, listid, backward, false)
         }
       }
     }
@@ -570,6 +799,130 @@ func PullFunc(w http.ResponseWriter, r *http.Request) {
   })
 }
 
+/* Issue body as the card says it should be: description, checklist and the files */
+func renderBody(card *trello.Card) string {
+  newbody := card.Desc
+  if card.Checklist != nil { /* We may have deleted the checklist */
+    newbody = newbody + card.Checklist.Render()
+  }
+  return t2gBody(newbody, card.Issue.RepoId) + card.Issue.RenderAttachments()
+}
+
+/* Moves the cards of all issues affected by the PR to the list, revisit once the commits have changed */
+func movePullIssues(pull *github.Pull, listid string, backward bool, revisit bool) (int, string) {
+  present := NewSet()
+  for _, v := range pull.AffectedIssues() {
+    if card := refCard(v); card != nil {
+      if v.Closing {
+        autoMove(card, listid, backward, "pull request " + pull.String(),
+          &moveCause{ Repo: pull.RepoId, Pull: pull.String(), Commits: v.Commits, Described: v.Described })
+        present[card.Id] = true
+      }
+      /* Mentions only get a link, so do directly referenced cards */
+      if !v.Closing || v.Issue == nil {
+        card.AttachOnce(pull.URL)
+      }
+    } else {
+      /* One unlinked reference shouldn't hold the others back */
+      Warnf("Can't find the card for %s", v.String())
+    }
+  }
+
+  /* History might have been rewritten */
+  if revisit {
+    revisitPullMoves(pull, present)
+  }
+
+  return http.StatusOK, "Cards moved."
+}
+
+func ReviewFunc(w http.ResponseWriter, r *http.Request) {
+  GeneralisedProcess(w, r, func (body []byte) (int, string) {
+    /* TODO check json errors */
+    var payload github.Payload
+    json.Unmarshal(body, &payload)
+    Infof("GitHub review event %s %s", payload.Action, payload.Review.State)
+
+    /* Dismissals and edits don't change anything for us */
+    if payload.Action == "submitted" {
+      if labelid := trello_obj.GetLabel(payload.Repo.Spec); len(labelid) > 0 {
+        pull := github_obj.GetPull(payload.Repo.Spec, payload.Pull.IssueNo)
+        pull.Refresh(&payload.Pull)
+
+        switch (payload.Review.State) {
+        case "changes_requested":
+          return movePullIssues(pull, trello_obj.Lists.InWorksId, true, false)
+        case "approved":
+          /* Only if we were told where to */
+          if listid := trello_obj.Lists.ByName(config.ApprovedList); len(listid) > 0 {
+            return movePullIssues(pull, listid, false, false)
+          }
+        }
+      }
+    }
+
+    return http.StatusOK, "Review noted."
+  })
+}
+
+/* Finds the card for an issue or a directly referenced one */
+func refCard(ref *github.Reference) *trello.Card {
+  if ref.Issue != nil {
+    return trello_obj.FindCard(ref.Issue.String())
+  }
+  return trello_obj.FindCardByLink(ref.Card)
+}
+
+/* Moves made by us rather than people, these never go back in the workflow unless allowed to.
+   If the cause is given, it's remembered for the case of rewritten history.
+   Returns whether the card is in the list afterwards */
+func autoMove(card *trello.Card, listid string, backward bool, reason string, cause *moveCause) bool {
+  from := card.ListId
+  if cause != nil {
+    defer rememberMove(card, from, cause)
+  }
+
+  if card.ListId == listid {
+    return true
+  }
+
+  if from, to := trello_obj.Lists.Rank(card.ListId), trello_obj.Lists.Rank(listid);
+    !backward && from >= 0 && to >= 0 && to < from {
+    Warnf("Not moving card %s back from %s to %s on %s.", card.Id,
+      trello_obj.Lists.NameOf(card.ListId), trello_obj.Lists.NameOf(listid), reason)
+    /* Tell people on the card, but only once per target */
+    if cache.SkippedMoves[card.Id] != listid {
+      cache.SkippedMoves[card.Id] = listid
+      card.AddComment(fmt.Sprintf("Not moving the card back to %s on %s, it's further down the workflow already.",
+        trello_obj.Lists.NameOf(listid), reason))
+    }
+    return false
+  }
+
+  delete(cache.SkippedMoves, card.Id)
+  card.Move(listid)
+  return true
+}
+
+/* Which list the cards go to when something lands on a branch, empty string if none */
+func listByBranch(branch string) string {
+  /* Tags and optional branches */
+  if len(branch) <= 0 {
+    return ""
+  }
+
+  switch branch {
+  case config.StableBranch:
+    return trello_obj.Lists.AcceptId
+  case config.UnstableBranch:
+    return trello_obj.Lists.MergedId
+  case config.TestBranch:
+    return trello_obj.Lists.DeployId
+  }
+
+  return ""
+}
+
 func PushFunc(w http.ResponseWriter, r *http.Request) {
   GeneralisedProcess(w, r, func (body []byte) (int, string) {
     /* TODO check json errors */
@@ -577,33 +930,246 @@ func PushFunc(w http.ResponseWriter, r *http.Request) {
     /* TODO check whether we serve this repo */
     var payload github.Push
     json.Unmarshal(body, &payload)
-    log.Printf("[Github push]")
+    Infof("GitHub push event")
     payload.SetGitHub(github_obj)
 
     if labelid := trello_obj.GetLabel(payload.Repo.Spec); len(labelid) > 0 {
-      for _, v := range payload.AffectedIssues() {
-        if card := trello_obj.FindCard(v.String()); card != nil {
-          var listid string
-          switch payload.Branch {
-          case config.StableBranch:
-            listid = trello_obj.Lists.AcceptId
-          case config.UnstableBranch:
-            listid = trello_obj.Lists.MergedId
-          case config.TestBranch:
-            listid = trello_obj.Lists.DeployId
-          default:
-            // attach feature branch #34
+      /* Tags are releases too */
+      if len(payload.Tag) > 0 && !payload.Deleted {
+        return processRelease(payload.Repo.Spec, payload.Tag, payload.ReleaseURL())
+      }
+
+      if listid := listByBranch(payload.Branch); len(listid) > 0 {
+        /* Half of a push would move some cards and lose the rest */
+        refs, err := payload.AffectedIssues()
+        if err != nil {
+          Errorf("Can't read the commits of the push to %s: %s", payload.Branch, err)
+          return http.StatusBadGateway, "Can't read the commits."
+        }
+        reverts, err := payload.Reverts()
+        if err != nil {
+          Errorf("Can't read the commits of the push to %s: %s", payload.Branch, err)
+          return http.StatusBadGateway, "Can't read the commits."
+        }
+
+        for _, v := range refs {
+          if card := refCard(v); card != nil {
+            if v.Closing {
+              autoMove(card, listid, false, "push to " + payload.Branch,
+                &moveCause{ Repo: payload.Repo.Spec, Branch: payload.Branch, Commits: v.Commits })
+            }
+            /* Mentions only get a link, so do directly referenced cards */
+            if !v.Closing || v.Issue == nil {
+              for _, sha := range v.Commits {
+                card.AttachOnce(github.CommitURL(payload.Repo.Spec, sha))
+              }
+            }
+          } else {
+            Warnf("Can't find the card for %s", v.String())
           }
+        }
+
+        /* Some of the earlier commits might be gone */
+        if payload.Forced {
+          revisitBranchMoves(payload.Repo.Spec, payload.Branch, refs)
+        }
 
-          if len(listid) > 0 && card.ListId != listid {
-            card.Move(listid)
+        /* Fixes that were taken back */
+        for _, v := range reverts {
+          processRevert(payload.Repo.Spec, payload.Branch, v)
+        }
+      } else if issue := payload.BranchIssue(config.BranchPattern); issue != nil {
+        /* Feature branch (#34), link it to the card */
+        if card := trello_obj.FindCard(issue.String()); card != nil {
+          if payload.Deleted {
+            card.DetachURL(payload.BranchURL())
+            return http.StatusOK, "Branch unlinked."
           }
+
+          card.AttachOnce(payload.BranchURL())
+          /* Somebody is clearly working on it */
+          if card.ListId == trello_obj.Lists.InboxId {
+            autoMove(card, trello_obj.Lists.InWorksId, false, "branch " + payload.Branch, nil)
+          }
+          return http.StatusOK, "Branch linked."
+        } else {
+          Warnf("Can't find the card for issue %s", issue.String())
+        }
+      }
+    }
+
+    return http.StatusOK, "I can't really process this, but fine."
+  })
+}
+
+func DeployFunc(w http.ResponseWriter, r *http.Request) {
+  GeneralisedProcess(w, r, func (body []byte) (int, string) {
+    /* TODO check json errors */
+    var payload github.Payload
+    json.Unmarshal(body, &payload)
+    env := payload.Deployment.Environment
+    Infof("GitHub deployment event %s %s", env, payload.DeploymentStatus.State)
+
+    /* Only finished deployments to environments we track */
+    listid := trello_obj.Lists.ByName(config.DeployLists[env])
+    if payload.DeploymentStatus.State != "success" || len(listid) <= 0 {
+      return http.StatusOK, "Not interested in this one."
+    }
+
+    if labelid := trello_obj.GetLabel(payload.Repo.Spec); len(labelid) > 0 {
+      /* Everything since the last time it went to this environment */
+      base, err := github_obj.PreviousDeployment(payload.Repo.Spec, env, payload.Deployment.Id)
+      if err != nil {
+        /* Not knowing where we were is not the same as not having been anywhere */
+        Errorf("Can't find the previous deployment to %s: %s", env, err)
+        return http.StatusBadGateway, "Can't find the previous deployment."
+      }
+      if len(base) <= 0 {
+        Infof("No previous deployment to %s, nothing to compare with.", env)
+        return http.StatusOK, "First deployment noted."
+      }
+
+      refs, err := github_obj.IssuesBetween(payload.Repo.Spec, base, payload.Deployment.Sha)
+      if err != nil {
+        Errorf("Can't compare %s with the previous deployment to %s: %s", payload.Deployment.Sha, env, err)
+        return http.StatusBadGateway, "Can't compare with the previous deployment."
+      }
+      for _, v := range refs {
+        if !v.Closing {
+          continue
+        }
+        if card := refCard(v); card != nil {
+          autoMove(card, listid, false, "deployment to " + env, nil)
         } else {
-          log.Printf("Can't find the card for issue %s", v.String())
+          Warnf("Can't find the card for %s", v.String())
         }
       }
+      return http.StatusOK, "Deployment processed."
     }
 
     return http.StatusOK, "I can't really process this, but fine."
   })
 }
+
+/* Moves the cards of the issues closed by a reverted commit back and tells everyone why */
+func processRevert(repoid string, branch string, revert *github.Revert) {
+  listid := trello_obj.Lists.ByName(config.RevertList)
+
+  for _, v := range revert.Issues {
+    if card := trello_obj.FindCard(v.String()); card != nil {
+      Infof("Commit %s reverts %s which closed %s.", revert.Commit, revert.Reverted, v.String())
+      if len(listid) > 0 {
+        autoMove(card, listid, true, "revert on " + branch, nil)
+      }
+
+      text := fmt.Sprintf("Commit %s on `%s` reverts %s which closed this issue.",
+        github.CommitURL(repoid, revert.Commit), branch, github.CommitURL(repoid, revert.Reverted))
+      card.AddComment(text)
+      v.AddComment(text)
+    } else {
+      Warnf("Can't find the card for issue %s", v.String())
+    }
+  }
+}
+
+func ReleaseFunc(w http.ResponseWriter, r *http.Request) {
+  GeneralisedProcess(w, r, func (body []byte) (int, string) {
+    /* TODO check json errors */
+    var payload github.Payload
+    json.Unmarshal(body, &payload)
+    Infof("GitHub release event %s %s", payload.Action, payload.Release.Tag)
+
+    if payload.Action == "published" {
+      if labelid := trello_obj.GetLabel(payload.Repo.Spec); len(labelid) > 0 {
+        return processRelease(payload.Repo.Spec, payload.Release.Tag, payload.Release.URL)
+      }
+    }
+
+    return http.StatusOK, "I can't really process this, but fine."
+  })
+}
+
+/* Workflow labels renamed or deleted on GitHub get their names back or are recreated */
+func LabelFunc(w http.ResponseWriter, r *http.Request) {
+  GeneralisedProcess(w, r, func (body []byte) (int, string) {
+    /* TODO check json errors */
+    var payload github.Payload
+    json.Unmarshal(body, &payload)
+    Infof("GitHub label event %s %s", payload.Action, payload.Label.Name)
+
+    repoid := payload.Repo.Spec
+    if len(trello_obj.GetLabel(repoid)) == 0 {
+      return http.StatusNotFound, "You sure we serve this repo? I don't think so."
+    }
+
+    workflow := make(map[string]github.LabelSpec)
+    for _, v := range trello.WorkflowLabels() {
+      workflow[strings.ToLower(v.Name)] = v
+    }
+
+    /* People may have their reasons, so it's only reported; POST /admin/labels puts them back */
+    switch payload.Action {
+    case "edited":
+      if _, ok := workflow[strings.ToLower(payload.Changes.Name.From)]; ok && len(payload.Changes.Name.From) > 0 {
+        Warnf("Workflow label %s was renamed to %s in %s, see /admin/labels.", payload.Changes.Name.From, payload.Label.Name, repoid)
+        return http.StatusOK, "That one is ours, reported."
+      }
+      if spec, ok := workflow[strings.ToLower(payload.Label.Name)]; ok &&
+        (!strings.EqualFold(payload.Label.Color, spec.Color) || payload.Label.Description != spec.Description) {
+        Warnf("Workflow label %s was changed in %s, see /admin/labels.", payload.Label.Name, repoid)
+        return http.StatusOK, "That one is ours, reported."
+      }
+    case "deleted":
+      if _, ok := workflow[strings.ToLower(payload.Label.Name)]; ok {
+        Warnf("Workflow label %s was deleted in %s, see /admin/labels.", payload.Label.Name, repoid)
+        return http.StatusOK, "That one is ours, reported."
+      }
+    }
+
+    return http.StatusOK, "Not one of ours, fine."
+  })
+}
+
+/* Moves the cards of the issues closed since the previous tag, labels them with the release and attaches it */
+func processRelease(repoid string, tag string, releaseURL string) (int, string) {
+  base, err := github_obj.PreviousTag(repoid, tag)
+  if err != nil {
+    Errorf("Can't find the tag before %s in %s: %s", tag, repoid, err)
+    return http.StatusBadGateway, "Can't find the previous tag."
+  }
+  if len(base) <= 0 {
+    Infof("No tag before %s in %s, nothing to compare with.", tag, repoid)
+    return http.StatusOK, "First release noted."
+  }
+
+  listid := trello_obj.Lists.ByName(config.ReleaseList)
+  labelname := "release " + tag
+
+  refs, err := github_obj.IssuesBetween(repoid, base, tag)
+  if err != nil {
+    Errorf("Can't compare %s with %s in %s: %s", tag, base, repoid, err)
+    return http.StatusBadGateway, "Can't compare with the previous tag."
+  }
+  for _, v := range refs {
+    if !v.Closing {
+      continue
+    }
+    if card := refCard(v); card != nil {
+      if len(listid) > 0 {
+        autoMove(card, listid, false, "release " + tag, nil)
+      }
+
+      /* One label per release, shared between the cards */
+      labelid := trello_obj.GetLabel(labelname)
+      if len(labelid) <= 0 {
+        labelid = trello_obj.AddLabel(labelname)
+      }
+      card.SetLabel(labelid)
+      card.AttachOnce(releaseURL)
+    } else {
+      Warnf("Can't find the card for %s", v.String())
+    }
+  }
+
+  return http.StatusOK, "Release processed."
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+  "net/http"
+  "strconv"
+  "sync"
+  "time"
+  . "github.com/ErintLabs/trellohub/genapi"
+)
+
+var webhookEvents = NewCounter("trellohub_webhook_events_total", "Webhook events received, by the result code.", "source", "type", "code")
+var loopSuppressed = NewCounter("trellohub_loop_suppressed_total", "Events recognised as echoes of our own changes and not mirrored back.", "kind")
+var mutexWait = NewHistogram("trellohub_mutex_wait_seconds", "Time events waited for the previous ones to be processed.", DefaultBuckets)
+var mutexWaiting = NewGauge("trellohub_mutex_waiting", "Events waiting for the previous ones to be processed right now.")
+var mutexOldest = NewGauge("trellohub_mutex_oldest_wait_seconds", "How long the longest waiting event has been waiting, as of the last scrape.")
+var cacheSizes = NewGauge("trellohub_cache_entries", "Entries in the caches, as of the last processed event.", "cache")
+
+/* Events queueing up on the mutex and since when, guarded separately so that scrapes don't queue up too */
+var waiters struct {
+  since   map[int64]time.Time
+  next    int64
+  mutex   sync.Mutex
+}
+
+/* Locks the big mutex while keeping track of how long it takes */
+func lockCache() {
+  waiters.mutex.Lock()
+  if waiters.since == nil {
+    waiters.since = make(map[int64]time.Time)
+  }
+  id, start := waiters.next, time.Now()
+  waiters.next++
+  waiters.since[id] = start
+  waiters.mutex.Unlock()
+  mutexWaiting.Add(1)
+
+  cache.mutex.Lock()
+
+  waiters.mutex.Lock()
+  delete(waiters.since, id)
+  waiters.mutex.Unlock()
+  mutexWaiting.Add(-1)
+  mutexWait.Observe(time.Since(start).Seconds())
+}
+
+/* Where the event came from, GitHub always tells */
+func eventSource(r *http.Request) string {
+  if len(r.Header.Get("X-GitHub-Event")) > 0 {
+    return "github"
+  }
+  return "trello"
+}
+
+func countEvent(r *http.Request, evt string, code int) {
+  webhookEvents.Inc(eventSource(r), evt, strconv.Itoa(code))
+}
+
+/* Called under the mutex, so that the maps are not being written to meanwhile */
+func updateCacheSizes() {
+  sizes := map[string]int {
+    "moves": len(cache.Moves),
+    "skipped_moves": len(cache.SkippedMoves),
+    "sync_reports": len(cache.SyncReports),
+    "users": len(cache.GitHubUserByTrello),
+    "unmapped_users": len(cache.UnmappedUsers),
+  }
+  for k, v := range trello_obj.CacheSizes() {
+    sizes[k] = v
+  }
+  for k, v := range github_obj.CacheSizes() {
+    sizes[k] = v
+  }
+  for k, v := range sizes {
+    cacheSizes.Set(float64(v), k)
+  }
+}
+
+func MetricsFunc(w http.ResponseWriter, r *http.Request) {
+  /* The oldest waiter is only interesting at the moment of the scrape */
+  oldest := 0.0
+  waiters.mutex.Lock()
+  for _, v := range waiters.since {
+    if age := time.Since(v).Seconds(); age > oldest {
+      oldest = age
+    }
+  }
+  waiters.mutex.Unlock()
+  mutexOldest.Set(oldest)
+
+  w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+  WriteMetrics(w)
+}
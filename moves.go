@@ -0,0 +1,127 @@
+package main
+
+import (
+  "sort"
+  . "github.com/ErintLabs/trellohub/genapi"
+  "github.com/ErintLabs/trellohub/trello"
+  "github.com/ErintLabs/trellohub/github"
+)
+
+/* Why a card was moved automatically, so that the move can be undone once the history is rewritten */
+type moveCause struct {
+  Repo      string    `json:"repo"`
+  Branch    string    `json:"branch,omitempty"`  // for pushes
+  Pull      string    `json:"pull,omitempty"`    // for pull requests
+  Commits   []string  `json:"commits"`
+  Described bool      `json:"described,omitempty"` // referenced in the pull request description
+  From      string    `json:"from"`
+  To        string    `json:"to"`
+}
+
+func (cause *moveCause) sameAs(other *moveCause) bool {
+  return cause.Repo == other.Repo && cause.Branch == other.Branch && cause.Pull == other.Pull
+}
+
+/* Remembers the last automatic move of the card, if it's the same reason just adds the commits */
+func rememberMove(card *trello.Card, from string, cause *moveCause) {
+  if from != card.ListId {
+    rec := *cause
+    rec.From, rec.To = from, card.ListId
+    cache.Moves[card.Id] = &rec
+    return
+  }
+
+  /* Otherwise we only care if it was us who put it there */
+  if rec := cache.Moves[card.Id]; rec != nil && rec.To == card.ListId && rec.sameAs(cause) {
+    rec.Described = rec.Described || cause.Described
+    known := NewSet()
+    known.SetNameable(rec.Commits)
+    for _, v := range cause.Commits {
+      if !known[v] {
+        rec.Commits = append(rec.Commits, v)
+      }
+    }
+  }
+}
+
+/* Puts the card back where it was before the move, unless somebody moved it since */
+func undoMove(cardid string, rec *moveCause, reason string) {
+  delete(cache.Moves, cardid)
+
+  card := trello_obj.GetCard(cardid)
+  if card.ListId != rec.To {
+    Infof("Card %s was moved since, not moving it back on %s.", cardid, reason)
+    return
+  }
+
+  Infof("Moving card %s back to %s on %s.", cardid, trello_obj.Lists.NameOf(rec.From), reason)
+  card.Move(rec.From)
+  card.AddComment("Moved the card back to " + trello_obj.Lists.NameOf(rec.From) + " on " + reason +
+    ", the commits that moved it are gone.")
+}
+
+/* After a force push, checks which commits are still on the branch and undoes the moves left without any */
+func revisitBranchMoves(repoid string, branch string, fresh []*github.Reference) {
+  reachable := func (sha string) (bool, error) {
+    return github_obj.Reachable(repoid, sha, branch)
+  }
+  for _, cardid := range orphanedMoves(repoid, branch, fresh, reachable) {
+    undoMove(cardid, cache.Moves[cardid], "force push to " + branch)
+  }
+}
+
+/* Drops the commits gone from the branch from the moves and lists the cards whose moves have none left, in order */
+func orphanedMoves(repoid string, branch string, fresh []*github.Reference, reachable func (sha string) (bool, error)) []string {
+  /* No need to ask about what we just got */
+  known := NewSet()
+  for _, ref := range fresh {
+    for _, v := range ref.Commits {
+      known[v] = true
+    }
+  }
+
+  res := make([]string, 0)
+  for cardid, rec := range cache.Moves {
+    if rec.Repo != repoid || rec.Branch != branch {
+      continue
+    }
+
+    alive := make([]string, 0, len(rec.Commits))
+    failed := false
+    for _, v := range rec.Commits {
+      if known[v] {
+        alive = append(alive, v)
+        continue
+      }
+      ok, err := reachable(v)
+      if err != nil {
+        /* Can't tell, better leave the card be until the next push */
+        Errorf("Can't check whether %s is still on %s, not revisiting card %s: %s", v, branch, cardid, err)
+        failed = true
+        break
+      }
+      if ok {
+        alive = append(alive, v)
+      }
+    }
+    if failed {
+      continue
+    }
+    rec.Commits = alive
+
+    if len(alive) == 0 {
+      res = append(res, cardid)
+    }
+  }
+  sort.Strings(res)
+  return res
+}
+
+/* Once the PR commits change, undoes the moves of the cards it doesn't reference anymore */
+func revisitPullMoves(pull *github.Pull, present Set) {
+  for cardid, rec := range cache.Moves {
+    if rec.Pull == pull.String() && !present[cardid] {
+      undoMove(cardid, rec, "update of pull request " + pull.String())
+    }
+  }
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+  "errors"
+  "reflect"
+  "testing"
+  "github.com/ErintLabs/trellohub/trello"
+  "github.com/ErintLabs/trellohub/github"
+)
+
+func TestRememberMove(t *testing.T) {
+  push := &moveCause{ Repo: "owner/repo", Branch: "dev", Commits: []string{ "b" } }
+  cases := []struct {
+    name    string
+    before  *moveCause
+    from    string
+    cause   *moveCause
+    after   *moveCause
+  }{
+    { "moved", nil, "inbox", push,
+      &moveCause{ Repo: "owner/repo", Branch: "dev", Commits: []string{ "b" }, From: "inbox", To: "merged" } },
+    { "moved again", &moveCause{ Repo: "owner/repo", Pull: "owner/repo#3", Commits: []string{ "a" }, From: "inbox", To: "review" }, "review", push,
+      &moveCause{ Repo: "owner/repo", Branch: "dev", Commits: []string{ "b" }, From: "review", To: "merged" } },
+    /* Already there for the same reason, the commits add up */
+    { "same reason", &moveCause{ Repo: "owner/repo", Branch: "dev", Commits: []string{ "a", "b" }, From: "inbox", To: "merged" }, "merged",
+      &moveCause{ Repo: "owner/repo", Branch: "dev", Commits: []string{ "b", "c" } },
+      &moveCause{ Repo: "owner/repo", Branch: "dev", Commits: []string{ "a", "b", "c" }, From: "inbox", To: "merged" } },
+    { "described", &moveCause{ Repo: "owner/repo", Pull: "owner/repo#3", Commits: []string{ "a" }, From: "inbox", To: "merged" }, "merged",
+      &moveCause{ Repo: "owner/repo", Pull: "owner/repo#3", Described: true },
+      &moveCause{ Repo: "owner/repo", Pull: "owner/repo#3", Commits: []string{ "a" }, Described: true, From: "inbox", To: "merged" } },
+    /* Somebody else put it there, or something else did */
+    { "other reason", &moveCause{ Repo: "owner/repo", Pull: "owner/repo#3", Commits: []string{ "a" }, From: "inbox", To: "merged" }, "merged", push,
+      &moveCause{ Repo: "owner/repo", Pull: "owner/repo#3", Commits: []string{ "a" }, From: "inbox", To: "merged" } },
+    { "not ours", nil, "merged", push, nil },
+  }
+
+  for _, c := range cases {
+    cache.Moves = make(map[string]*moveCause)
+    if c.before != nil {
+      cache.Moves["card"] = c.before
+    }
+    rememberMove(&trello.Card{ Id: "card", ListId: "merged" }, c.from, c.cause)
+    if after := cache.Moves["card"]; !reflect.DeepEqual(after, c.after) {
+      t.Errorf("%s: got %+v, want %+v", c.name, after, c.after)
+    }
+  }
+  cache.Moves = nil
+}
+
+func TestOrphanedMoves(t *testing.T) {
+  /* a is still there, b was force-pushed away, c can't be checked */
+  reachable := func (sha string) (bool, error) {
+    switch sha {
+    case "a":
+      return true, nil
+    case "c":
+      return false, errors.New("502 Bad Gateway")
+    }
+    return false, nil
+  }
+  fresh := []*github.Reference{ { Commits: []string{ "d" } } }
+
+  cases := []struct {
+    name    string
+    rec     *moveCause
+    orphan  bool
+    commits []string
+  }{
+    { "kept", &moveCause{ Repo: "owner/repo", Branch: "dev", Commits: []string{ "a", "b" } }, false, []string{ "a" } },
+    { "gone", &moveCause{ Repo: "owner/repo", Branch: "dev", Commits: []string{ "b" } }, true, []string{} },
+    { "just pushed", &moveCause{ Repo: "owner/repo", Branch: "dev", Commits: []string{ "b", "d" } }, false, []string{ "d" } },
+    /* Not knowing is not the same as gone */
+    { "unknown", &moveCause{ Repo: "owner/repo", Branch: "dev", Commits: []string{ "b", "c" } }, false, []string{ "b", "c" } },
+    { "other branch", &moveCause{ Repo: "owner/repo", Branch: "master", Commits: []string{ "b" } }, false, []string{ "b" } },
+    { "other repo", &moveCause{ Repo: "owner/lib", Branch: "dev", Commits: []string{ "b" } }, false, []string{ "b" } },
+    { "pull request", &moveCause{ Repo: "owner/repo", Pull: "owner/repo#3", Commits: []string{ "b" } }, false, []string{ "b" } },
+  }
+
+  cache.Moves = make(map[string]*moveCause)
+  want := make([]string, 0)
+  for _, c := range cases {
+    cache.Moves[c.name] = c.rec
+    if c.orphan {
+      want = append(want, c.name)
+    }
+  }
+
+  if res := orphanedMoves("owner/repo", "dev", fresh, reachable); !reflect.DeepEqual(res, want) {
+    t.Errorf("got %v, want %v", res, want)
+  }
+  for _, c := range cases {
+    if !reflect.DeepEqual(c.rec.Commits, c.commits) {
+      t.Errorf("%s: commits left %v, want %v", c.name, c.rec.Commits, c.commits)
+    }
+  }
+  cache.Moves = nil
+}
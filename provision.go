@@ -0,0 +1,47 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  . "github.com/ErintLabs/trellohub/genapi"
+  "github.com/ErintLabs/trellohub/trello"
+)
+
+/* Sets the board up for the workflow: shows what has to be done and, if asked to, does it */
+func provision(key string, token string, boardid string, apply bool) {
+  config.TrelloKey, config.TrelloToken, config.BoardId = key, token, boardid
+  trello_obj = trello.New(config.TrelloKey, config.TrelloToken, config.BoardId)
+  if len(trello_obj.BoardId) == 0 {
+    Fatalf("Can't find board %s, check the key and the token.", boardid)
+  }
+
+  plan, err := trello_obj.PlanProvision()
+  if err != nil {
+    Fatalf("Can't read the board: %s", err)
+  }
+
+  changes := 0
+  fmt.Println("Plan:")
+  for _, v := range plan {
+    fmt.Println("  " + v.String())
+    if v.Action != "keep" {
+      changes++
+    }
+  }
+
+  if changes == 0 {
+    fmt.Println("The board is set up already, nothing to do.")
+  } else if !apply {
+    fmt.Printf("%d changes to make, run again with apply at the end to make them.\n", changes)
+    return
+  }
+
+  if err := trello_obj.ApplyProvision(plan); err != nil {
+    Fatalf("Provisioning stopped halfway, running it again picks up from there: %s", err)
+  }
+
+  /* Happily print the JSON */
+  data, _ := json.Marshal(trello_obj.Lists)
+  fmt.Println("Set $LISTS to the following value:")
+  fmt.Println(string(data[:]))
+}
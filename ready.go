@@ -0,0 +1,154 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "sort"
+  "sync"
+  "time"
+  . "github.com/ErintLabs/trellohub/genapi"
+)
+
+/* What has to be done before we can handle events properly, "" once it's done, what's wrong otherwise.
+   Guarded separately, the startup holds the big mutex for a long while */
+var readiness struct {
+  components  map[string]string
+  mutex       sync.Mutex
+}
+
+const notYet = "pending"
+
+/* Events can't be handled properly without these */
+var readyComponents = []string { "trello_api", "github_api", "labels", "users", "cards" }
+/* These are only reported: Trello checks the callback before installing the hook, which it can't if we are not ready */
+var hookComponents = []string { "trello_hook", "github_hooks" }
+
+/* Failed steps are retried, waiting twice as long every time up to that */
+const maxRetryDelay = 5 * time.Minute
+
+func initReadiness() {
+  readiness.components = make(map[string]string)
+  for _, v := range append(readyComponents, hookComponents...) {
+    readiness.components[v] = notYet
+  }
+}
+
+func setReady(component string, err error) {
+  readiness.mutex.Lock()
+  defer readiness.mutex.Unlock()
+  if err != nil {
+    Errorf("%s is not ready: %s", component, err)
+    readiness.components[component] = err.Error()
+  } else {
+    Infof("%s is ready.", component)
+    readiness.components[component] = ""
+  }
+}
+
+/* Whether any of the components is not there yet */
+func pending(components ...string) bool {
+  readiness.mutex.Lock()
+  defer readiness.mutex.Unlock()
+  for _, v := range components {
+    if len(readiness.components[v]) > 0 {
+      return true
+    }
+  }
+  return false
+}
+
+/* Warms the caches up, does what the previous run couldn't, then sees to the hooks */
+func startup() {
+  retry("caches", warmup)
+  replayPending()
+  retry("hooks", ensureHooks)
+}
+
+/* Repeats the step with a growing delay until it says it's done */
+func retry(what string, step func () bool) {
+  for delay := time.Second; !step(); {
+    Background.Warnf("Not all of the %s are ready, trying again in %s.", what, delay)
+    time.Sleep(delay)
+    if delay *= 2; delay > maxRetryDelay {
+      delay = maxRetryDelay
+    }
+  }
+}
+
+/* Whatever isn't ready yet, holding the mutex so that events wait for it */
+func warmup() bool {
+  lockCache()
+  defer cache.mutex.Unlock()
+
+  if pending("trello_api") {
+    setReady("trello_api", trello_obj.CheckToken())
+  }
+  if pending("github_api") {
+    setReady("github_api", github_obj.CheckToken())
+  }
+
+  /* The caches are loaded together */
+  if pending("labels", "users", "cards") && !pending("trello_api") {
+    trello_obj.OnLoaded = setReady
+    trello_obj.Startup(github_obj)
+  }
+  return !pending(readyComponents...)
+}
+
+func ensureHooks() bool {
+  lockCache()
+  defer cache.mutex.Unlock()
+
+  if pending("trello_hook") {
+    setReady("trello_hook", trello_obj.EnsureHook(config.BaseURL + "/trello"))
+  }
+
+  if pending("github_hooks") {
+    var failed []string
+    for _, repo := range trello_obj.Repos() {
+      if err := github_obj.EnsureHook(repo, config.BaseURL); err != nil {
+        failed = append(failed, repo + ": " + err.Error())
+      }
+    }
+    if len(failed) > 0 {
+      sort.Strings(failed)
+      setReady("github_hooks", fmt.Errorf("%d of the repositories failed: %v", len(failed), failed))
+    } else {
+      setReady("github_hooks", nil)
+    }
+  }
+  return !pending(hookComponents...)
+}
+
+/* The process is up, that's all */
+func HealthFunc(w http.ResponseWriter, r *http.Request) {
+  fmt.Fprintln(w, "Still alive.")
+}
+
+/* 200 once everything is warmed up, 503 with what's missing otherwise.
+   What exactly went wrong is only for admins, it can mention the tokens */
+func ReadyFunc(w http.ResponseWriter, r *http.Request) {
+  detailed := adminAuthorised(r)
+  readiness.mutex.Lock()
+  status := make(map[string]string)
+  code := http.StatusOK
+  for k, v := range readiness.components {
+    if len(v) > 0 {
+      status[k] = v
+      if !detailed && v != notYet {
+        status[k] = "failed"
+      }
+    } else {
+      status[k] = "ok"
+    }
+  }
+  readiness.mutex.Unlock()
+  if pending(readyComponents...) {
+    code = http.StatusServiceUnavailable
+  }
+
+  w.Header().Set("Content-Type", "application/json")
+  w.WriteHeader(code)
+  json.NewEncoder(w).Encode(status)
+}
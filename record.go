@@ -0,0 +1,61 @@
+package main
+
+import (
+  "path/filepath"
+  "sort"
+  "strings"
+  . "github.com/ErintLabs/trellohub/genapi"
+)
+
+/* Stores the request as it came to $RECORD_DIR/<endpoint>.jsonl, in the same format as the pending events */
+func recordRaw(evt *storedEvent) {
+  if len(config.RecordDir) == 0 {
+    return
+  }
+
+  name := strings.Trim(evt.Endpoint, "/")
+  if len(name) == 0 || strings.ContainsAny(name, "/.") {
+    name = "other"
+  }
+  path := filepath.Join(config.RecordDir, name + ".jsonl")
+  if err := appendEvents(path, []*storedEvent{ evt }); err != nil {
+    Background.Errorf("Can't record the event to %s: %s", path, err)
+  }
+}
+
+/* Reads one recording or all of them in the directory, in the order they came */
+func readRecorded(path string) ([]*storedEvent, error) {
+  files := []string{ path }
+  if matches, err := filepath.Glob(filepath.Join(path, "*.jsonl")); err == nil && len(matches) > 0 {
+    files = matches
+  }
+
+  res := make([]*storedEvent, 0)
+  for _, v := range files {
+    events, err := readEvents(v)
+    if err != nil {
+      return nil, err
+    }
+    res = append(res, events...)
+  }
+  sort.SliceStable(res, func (i, j int) bool { return res[i].Time.Before(res[j].Time) })
+  return res, nil
+}
+
+/* Feeds the recorded events through the handlers one by one */
+func replay(path string) {
+  events, err := readRecorded(path)
+  if err != nil {
+    Fatalf("Can't read the recorded events from %s: %s", path, err)
+  }
+
+  /* Same caches as the server would have, no hooks or pending events though */
+  trello_obj.Startup(github_obj)
+  /* Done on purpose, so whatever was done already is done again */
+  cache.Events = nil
+  Background.Infof("Replaying %d events from %s.", len(events), path)
+  for _, v := range events {
+    code, text := dispatch(v)
+    Background.Infof("Replayed %s from %s: %d %s", v.Endpoint, v.Time.Format("2006-01-02 15:04:05"), code, text)
+  }
+}
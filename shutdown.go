@@ -0,0 +1,219 @@
+package main
+
+import (
+  "bufio"
+  "context"
+  "encoding/json"
+  "net/http"
+  "net/http/httptest"
+  "os"
+  "os/signal"
+  "strings"
+  "sync"
+  "syscall"
+  "time"
+  . "github.com/ErintLabs/trellohub/genapi"
+)
+
+/* A webhook request as it came, enough to feed it through the handlers again */
+type storedEvent struct {
+  Time      time.Time   `json:"time"`
+  Endpoint  string      `json:"endpoint"`
+  Header    http.Header `json:"header"`
+  Body      string      `json:"body"`
+}
+
+/* Events are kept one JSON object per line, appending never breaks what was there */
+func appendEvents(path string, events []*storedEvent) error {
+  file, err := os.OpenFile(path, os.O_APPEND | os.O_CREATE | os.O_WRONLY, 0600)
+  if err != nil {
+    return err
+  }
+
+  enc := json.NewEncoder(file)
+  for _, v := range events {
+    if err := enc.Encode(v); err != nil {
+      file.Close()
+      return err
+    }
+  }
+  return file.Close()
+}
+
+func readEvents(path string) ([]*storedEvent, error) {
+  file, err := os.Open(path)
+  if err != nil {
+    return nil, err
+  }
+  defer file.Close()
+
+  res := make([]*storedEvent, 0)
+  scanner := bufio.NewScanner(file)
+  scanner.Buffer(make([]byte, 64 << 10), 16 << 20) // pushes can be large
+  for scanner.Scan() {
+    if line := strings.TrimSpace(scanner.Text()); len(line) > 0 {
+      evt := new(storedEvent)
+      if err := json.Unmarshal([]byte(line), evt); err != nil {
+        return res, err
+      }
+      res = append(res, evt)
+    }
+  }
+  return res, scanner.Err()
+}
+
+/* Feeds the event through the same handler it came to */
+func dispatch(evt *storedEvent) (int, string) {
+  req := httptest.NewRequest("POST", evt.Endpoint, strings.NewReader(evt.Body))
+  for k, v := range evt.Header {
+    req.Header[k] = v
+  }
+  rec := httptest.NewRecorder()
+  http.DefaultServeMux.ServeHTTP(rec, req)
+  return rec.Code, strings.TrimSpace(rec.Body.String())
+}
+
+/* Events being processed or waiting for the mutex, so that the unfinished ones can be kept on shutdown */
+var inflight struct {
+  events    map[int64]*storedEvent
+  next      int64
+  draining  bool
+  abandoned bool
+  mutex     sync.Mutex
+}
+
+/* Registers the event, false if we are shutting down and don't take new ones */
+func track(evt *storedEvent) (int64, bool) {
+  inflight.mutex.Lock()
+  defer inflight.mutex.Unlock()
+  if inflight.draining {
+    return 0, false
+  }
+  if inflight.events == nil {
+    inflight.events = make(map[int64]*storedEvent)
+  }
+  inflight.next++
+  inflight.events[inflight.next] = evt
+  return inflight.next, true
+}
+
+func untrack(ticket int64) {
+  inflight.mutex.Lock()
+  defer inflight.mutex.Unlock()
+  delete(inflight.events, ticket)
+}
+
+/* Once the deadline has passed, the events still around are recorded and must not be processed anymore */
+func abandoned() bool {
+  inflight.mutex.Lock()
+  defer inflight.mutex.Unlock()
+  return inflight.abandoned
+}
+
+/* Serves until SIGTERM or SIGINT, then gives the events in progress $SHUTDOWN_TIMEOUT to finish */
+func serve() {
+  server := &http.Server{ Addr: ":" + config.Port }
+
+  stop := make(chan os.Signal, 1)
+  signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+  done := make(chan struct{})
+
+  go func () {
+    sig := <-stop
+    Background.Infof("Got %s, draining the events in progress for up to %s.", sig, config.ShutdownTimeout)
+    inflight.mutex.Lock()
+    inflight.draining = true
+    inflight.mutex.Unlock()
+
+    ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+    defer cancel()
+    if err := server.Shutdown(ctx); err != nil {
+      Background.Warnf("Not everything finished in time: %s", err)
+    }
+    finishShutdown()
+    close(done)
+  }()
+
+  if err := server.ListenAndServe(); err != http.ErrServerClosed {
+    Fatalf("%s", err)
+  }
+  <-done
+  Background.Infof("Bye.")
+}
+
+/* Records whatever is left and flushes the state */
+func finishShutdown() {
+  inflight.mutex.Lock()
+  inflight.abandoned = true
+  left := make([]*storedEvent, 0, len(inflight.events))
+  for i := int64(1); i <= inflight.next; i++ {
+    if evt := inflight.events[i]; evt != nil {
+      left = append(left, evt) // in the order they came
+    }
+  }
+  inflight.mutex.Unlock()
+
+  if len(left) > 0 {
+    if err := appendEvents(config.PendingFile, left); err != nil {
+      Background.Errorf("Can't record %d unfinished events to %s: %s", len(left), config.PendingFile, err)
+    } else {
+      Background.Warnf("Recorded %d unfinished events to %s, they will be replayed on the next start.", len(left), config.PendingFile)
+    }
+  }
+
+  /* The user table and the state are saved after every event, this is for the one that was in progress.
+     If it's stuck with the mutex, what was saved before it is what we have */
+  if lockWithin(5 * time.Second) {
+    saveUsers()
+    saveState()
+    cache.mutex.Unlock()
+  } else {
+    Background.Warnf("An event is still holding the state, keeping what was saved before it.")
+  }
+}
+
+/* Same as lockCache, but gives up after a while */
+func lockWithin(timeout time.Duration) bool {
+  for deadline := time.Now().Add(timeout); !cache.mutex.TryLock(); {
+    if time.Now().After(deadline) {
+      return false
+    }
+    time.Sleep(50 * time.Millisecond)
+  }
+  return true
+}
+
+/* Replays the events left over by the previous shutdown, called once the caches are warm */
+func replayPending() {
+  events, err := readEvents(config.PendingFile)
+  if os.IsNotExist(err) {
+    return
+  }
+  if err != nil {
+    /* Whatever is past the broken line stays there for people to look at */
+    aside := config.PendingFile + ".broken-" + time.Now().Format("20060102150405")
+    Background.Errorf("Can't read all of the unfinished events from %s, moving it to %s: %s", config.PendingFile, aside, err)
+    if err := os.Rename(config.PendingFile, aside); err != nil {
+      Background.Errorf("Can't move %s aside, not replaying anything: %s", config.PendingFile, err)
+      return
+    }
+  } else {
+    /* If we are interrupted again, whatever is not done yet is recorded anew */
+    os.Remove(config.PendingFile)
+  }
+
+  Background.Infof("Replaying %d unfinished events from the previous run.", len(events))
+  for _, v := range events {
+    code, text := dispatch(v)
+    Background.Infof("Replayed %s: %d %s", v.Endpoint, code, text)
+  }
+}
+
+/* Parses $SHUTDOWN_TIMEOUT */
+func parseTimeout(value string) time.Duration {
+  res, err := time.ParseDuration(value)
+  if err != nil || res < 0 {
+    Fatalf("$SHUTDOWN_TIMEOUT must be a duration like 25s, not %s.", value)
+  }
+  return res
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+  "bytes"
+  "encoding/json"
+  . "github.com/ErintLabs/trellohub/genapi"
+)
+
+/* What trellohub remembers between the events and has to survive restarts, kept in $STATE_STORE */
+type persistentState struct {
+  Moves         map[string]*moveCause   `json:"moves"`
+  SkippedMoves  map[string]string       `json:"skipped_moves"`
+  SyncReports   map[string]*syncReport  `json:"sync_reports"`
+  Events        []eventState            `json:"events"`
+}
+
+/* What was written last time, so that unchanged state is not written again */
+var savedState []byte
+
+func loadState() {
+  if len(config.StateStore) == 0 {
+    return
+  }
+
+  var state persistentState
+  if loadJSON(config.StateStore, &state) {
+    Infof("Using the state from %s: %d moves, %d sync reports.", config.StateStore, len(state.Moves), len(state.SyncReports))
+    if state.Moves != nil {
+      cache.Moves = state.Moves
+    }
+    if state.SkippedMoves != nil {
+      cache.SkippedMoves = state.SkippedMoves
+    }
+    if state.SyncReports != nil {
+      cache.SyncReports = state.SyncReports
+    }
+    cache.Events = state.Events
+  }
+}
+
+/* Called after every event and on shutdown, with the mutex held */
+func saveState() {
+  if len(config.StateStore) == 0 {
+    return
+  }
+
+  state := &persistentState{ cache.Moves, cache.SkippedMoves, cache.SyncReports, cache.Events }
+  data, _ := json.Marshal(state)
+  if bytes.Equal(data, savedState) {
+    return
+  }
+  if err := saveJSON(config.StateStore, state); err != nil {
+    Errorf("Can't save the state: %s", err)
+    return
+  }
+  savedState = data
+}
+
+/* Events we've started or finished, by their delivery or action id, so that redeliveries and replays are not done twice */
+type eventState struct {
+  Id      string          `json:"id"`
+  Status  string          `json:"status"`
+  Done    []JournalEntry  `json:"done,omitempty"` // changes made so far, while it's started
+}
+
+const (
+  eventStarted  = "started"   // and not finished yet, if it's still there after a restart we went down meanwhile
+  eventDone     = "done"
+  eventFailed   = "failed"    // can be done again
+)
+
+/* That's a few days worth of events */
+const eventMemory = 1000
+
+func eventStatus(id string) string {
+  for i := len(cache.Events) - 1; i >= 0; i-- {
+    if cache.Events[i].Id == id {
+      return cache.Events[i].Status
+    }
+  }
+  return ""
+}
+
+/* Changes the interrupted event made before, see genapi.StartJournal */
+func eventJournal(id string) []JournalEntry {
+  for i := len(cache.Events) - 1; i >= 0; i-- {
+    if cache.Events[i].Id == id {
+      return cache.Events[i].Done
+    }
+  }
+  return nil
+}
+
+/* Records the changes the event made so far and saves them right away, they are what we go on after a crash */
+func journalEvent(entries []JournalEntry) {
+  id := Correlation()
+  for i := len(cache.Events) - 1; i >= 0; i-- {
+    if cache.Events[i].Id == id {
+      cache.Events[i].Done = entries
+      saveState()
+      return
+    }
+  }
+}
+
+/* Started events keep the changes made before, the finished ones don't need them anymore */
+func noteEvent(id string, status string) {
+  if len(id) == 0 {
+    return
+  }
+  var done []JournalEntry
+  for i := range cache.Events {
+    if cache.Events[i].Id == id {
+      if status == eventStarted {
+        done = cache.Events[i].Done
+      }
+      cache.Events = append(cache.Events[:i], cache.Events[i+1:]...)
+      break
+    }
+  }
+  cache.Events = append(cache.Events, eventState{ id, status, done })
+  if len(cache.Events) > eventMemory {
+    cache.Events = cache.Events[len(cache.Events) - eventMemory:]
+  }
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+  "reflect"
+  "strconv"
+  "testing"
+  . "github.com/ErintLabs/trellohub/genapi"
+)
+
+func TestNoteEvent(t *testing.T) {
+  journal := []JournalEntry{ { Key: "POST /cards/ x" } }
+  cases := []struct {
+    name    string
+    events  []eventState
+    id      string
+    status  string
+    res     []eventState
+  }{
+    { "new", nil, "a", eventStarted, []eventState{ { "a", eventStarted, nil } } },
+    { "finished", []eventState{ { "a", eventStarted, journal }, { "b", eventDone, nil } }, "a", eventDone,
+      []eventState{ { "b", eventDone, nil }, { "a", eventDone, nil } } },
+    { "restarted", []eventState{ { "a", eventStarted, journal } }, "a", eventStarted, []eventState{ { "a", eventStarted, journal } } },
+    { "failed", []eventState{ { "a", eventStarted, journal } }, "a", eventFailed, []eventState{ { "a", eventFailed, nil } } },
+    { "no id", []eventState{ { "a", eventDone, nil } }, "", eventDone, []eventState{ { "a", eventDone, nil } } },
+  }
+
+  for _, c := range cases {
+    cache.Events = append([]eventState{}, c.events...)
+    noteEvent(c.id, c.status)
+    if !reflect.DeepEqual(cache.Events, c.res) {
+      t.Errorf("%s: got %v, want %v", c.name, cache.Events, c.res)
+    }
+    if status := eventStatus(c.id); len(c.id) > 0 && status != c.status {
+      t.Errorf("%s: status %s, want %s", c.name, status, c.status)
+    }
+  }
+  cache.Events = nil
+}
+
+func TestEventMemory(t *testing.T) {
+  cache.Events = nil
+  for i := 0; i < eventMemory + 10; i++ {
+    noteEvent(strconv.Itoa(i), eventDone)
+  }
+
+  cases := []struct {
+    id      string
+    status  string
+  }{
+    { "0", "" },
+    { "9", "" },
+    { "10", eventDone },
+    { strconv.Itoa(eventMemory + 9), eventDone },
+    { "unknown", "" },
+  }
+  for _, c := range cases {
+    if status := eventStatus(c.id); status != c.status {
+      t.Errorf("%s: status %q, want %q", c.id, status, c.status)
+    }
+  }
+  if len(cache.Events) != eventMemory {
+    t.Errorf("%d events remembered, want %d", len(cache.Events), eventMemory)
+  }
+  cache.Events = nil
+}
+
+func TestJournalEvent(t *testing.T) {
+  cache.Events = []eventState{ { "a", eventStarted, nil }, { "b", eventStarted, nil } }
+  defer SetCorrelation("")
+  SetCorrelation("b")
+
+  journal := []JournalEntry{ { Key: "k" } }
+  journalEvent(journal)
+  if cache.Events[0].Done != nil || !reflect.DeepEqual(cache.Events[1].Done, journal) {
+    t.Errorf("journal went to the wrong event: %v", cache.Events)
+  }
+  if !reflect.DeepEqual(eventJournal("b"), journal) || eventJournal("a") != nil {
+    t.Errorf("eventJournal doesn't give it back")
+  }
+  cache.Events = nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+  "encoding/json"
+  "io/ioutil"
+  "os"
+  . "github.com/ErintLabs/trellohub/genapi"
+)
+
+/* Reads a JSON file into v, false if there is none or it's broken */
+func loadJSON(path string, v interface{}) bool {
+  data, err := ioutil.ReadFile(path)
+  if err != nil {
+    if !os.IsNotExist(err) {
+      Errorf("Can't read %s: %s", path, err)
+    }
+    return false
+  }
+
+  if err := json.Unmarshal(data, v); err != nil {
+    Errorf("Can't parse %s: %s", path, err)
+    return false
+  }
+  return true
+}
+
+/* Writes v into the file, through a temporary one so that a crash doesn't leave half of it */
+func saveJSON(path string, v interface{}) error {
+  data, err := json.MarshalIndent(v, "", "  ")
+  if err != nil {
+    return err
+  }
+
+  if err := ioutil.WriteFile(path + ".tmp", data, 0600); err != nil {
+    return err
+  }
+  return os.Rename(path + ".tmp", path)
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "net/url"
+  "regexp"
+  "sort"
+  "strconv"
+  "strings"
+  . "github.com/ErintLabs/trellohub/genapi"
+  "github.com/ErintLabs/trellohub/trello"
+  "github.com/ErintLabs/trellohub/github"
+)
+
+/* What we told people about a failed sync, so that it can be taken back. Kept by ids, it's part of the state */
+type syncReport struct {
+  Failed        []string  `json:"failed"`         // operations that didn't go through, see operationOf
+  CardId        string    `json:"card,omitempty"`
+  CardComment   string    `json:"card_comment,omitempty"`
+  Issue         string    `json:"issue,omitempty"`
+  IssueComment  int       `json:"issue_comment,omitempty"`
+}
+
+/* A card and its issue fail and recover together, either can be missing */
+type syncEntity struct {
+  key     string
+  card    *trello.Card
+  issue   *github.Issue
+}
+
+func entityOf(call CallResult) *syncEntity {
+  card := trello_obj.CardByQuery(call.Query)
+  issue := github_obj.IssueByQuery(call.Query)
+  if card == nil && issue != nil {
+    card = trello_obj.FindCard(issue.String())
+  }
+  if issue == nil && card != nil {
+    issue = card.Issue
+  }
+
+  switch {
+  case card != nil:
+    return &syncEntity{ card.Id, card, issue }
+  case issue != nil:
+    return &syncEntity{ issue.String(), nil, issue }
+  }
+  return nil
+}
+
+/* Which change the call was, the values in the query don't matter: PUT /cards/<id>/idList */
+func operationOf(call CallResult) string {
+  return call.Method + " " + strings.SplitN(call.Query, "?", 2)[0]
+}
+
+/* Readable names of the changes, by operation. The values in the paths are left out, except for GitHub label names */
+var operationNames = []struct {
+  re    *regexp.Regexp
+  name  string
+}{
+  { regexp.MustCompile("^PUT /cards/[^/]+/idList$"), "moving the card" },
+  { regexp.MustCompile("^PUT /cards/[^/]+/name$"), "renaming the card" },
+  { regexp.MustCompile("^PUT /cards/[^/]+/desc$"), "updating the description of the card" },
+  { regexp.MustCompile("^POST /cards/[^/]+/idLabels$"), "adding a label to the card" },
+  { regexp.MustCompile("^DELETE /cards/[^/]+/idLabels/[^/]+$"), "removing a label from the card" },
+  { regexp.MustCompile("^POST /cards/[^/]+/idMembers$"), "adding a member to the card" },
+  { regexp.MustCompile("^DELETE /cards/[^/]+/idMembers/[^/]+$"), "removing a member from the card" },
+  { regexp.MustCompile("^POST /cards/[^/]+/attachments$"), "attaching a link to the card" },
+  { regexp.MustCompile("^DELETE /cards/[^/]+/attachments/[^/]+$"), "removing an attachment from the card" },
+  { regexp.MustCompile("^POST /cards/[^/]+/actions/comments$"), "commenting on the card" },
+  { regexp.MustCompile("^(?:POST|PUT|DELETE) /(?:cards?/[^/]+/checklists?|checklists/)"), "updating the checklist of the card" },
+  { regexp.MustCompile("^POST /cards/?$"), "creating the card" },
+  { regexp.MustCompile("^POST repos/[^/]+/[^/]+/issues/[0-9]+/labels$"), "adding a label to the issue" },
+  { regexp.MustCompile("^DELETE repos/[^/]+/[^/]+/issues/[0-9]+/labels/([^/]+)$"), "removing label `%s` from the issue" },
+  { regexp.MustCompile("^POST repos/[^/]+/[^/]+/issues/[0-9]+/assignees$"), "assigning people to the issue" },
+  { regexp.MustCompile("^DELETE repos/[^/]+/[^/]+/issues/[0-9]+/assignees$"), "unassigning people from the issue" },
+  { regexp.MustCompile("^PATCH repos/[^/]+/[^/]+/issues/[0-9]+$"), "updating the issue" },
+  { regexp.MustCompile("^POST repos/[^/]+/[^/]+/issues/[0-9]+/comments$"), "commenting on the issue" },
+}
+
+/* What the change was, in words: adding a label to the issue */
+func describeOperation(op string) string {
+  for _, v := range operationNames {
+    if res := v.re.FindStringSubmatch(op); res != nil {
+      if len(res) > 1 {
+        name, err := url.PathUnescape(res[1])
+        if err != nil || strings.ContainsAny(name, "`\n") {
+          name = "?"
+        }
+        return fmt.Sprintf(v.name, name)
+      }
+      return v.name
+    }
+  }
+  return "another change"
+}
+
+/* Why it failed, without anything the API might have put in there: 422 Validation Failed */
+func describeError(err error) string {
+  apierr, ok := err.(*APIError)
+  if !ok {
+    return "failed"
+  }
+  if apierr.Status == 0 {
+    return "no answer"
+  }
+
+  /* GitHub explains itself in short, Trello's text is the explanation; anything long or with links is replaced by the standard one */
+  text := apierr.Text
+  var data struct { Message string `json:"message"` }
+  if json.Unmarshal([]byte(text), &data) == nil {
+    text = data.Message
+  }
+  if len(text) == 0 || len(text) > 60 || strings.ContainsAny(text, "\n`<>[]") ||
+    regexp.MustCompile(REGEX_MD_URL).MatchString(text) {
+    text = http.StatusText(apierr.Status)
+  }
+  return strconv.Itoa(apierr.Status) + " " + text
+}
+
+/* Goes over the changes made while processing an event: reports the failed ones where people look
+   and takes back the earlier reports once every change that failed has gone through */
+func reportSync(calls []CallResult) {
+  entities := make(map[string]*syncEntity)
+  failed := make(map[string]Set)
+  succeeded := make(map[string]Set)
+  failures := make(map[string]map[string]CallResult)
+  event := ""
+  for _, v := range calls {
+    ent := entityOf(v)
+    if ent == nil {
+      continue
+    }
+    entities[ent.key] = ent
+    if failed[ent.key] == nil {
+      failed[ent.key], succeeded[ent.key] = NewSet(), NewSet()
+      failures[ent.key] = make(map[string]CallResult)
+    }
+
+    op := operationOf(v)
+    if v.Err != nil {
+      Warnf("Sync of %s failed: %s", ent.key, v.Err)
+      failed[ent.key][op], succeeded[ent.key][op] = true, false
+      failures[ent.key][op] = v
+      event = v.Event
+    } else {
+      failed[ent.key][op], succeeded[ent.key][op] = false, true
+    }
+  }
+
+  for key, ent := range entities {
+    rep := cache.SyncReports[key]
+    if rep == nil {
+      if ops := setKeys(failed[key]); len(ops) > 0 {
+        calls := make([]CallResult, 0, len(ops))
+        for _, op := range ops {
+          calls = append(calls, failures[key][op])
+        }
+        postSyncReport(key, ent, calls, event)
+      }
+      continue
+    }
+
+    /* Only the very operations that failed clear the report */
+    ops := NewSet()
+    ops.SetNameable(rep.Failed)
+    for k, ok := range succeeded[key] {
+      if ok {
+        delete(ops, k)
+      }
+    }
+    for k, ok := range failed[key] {
+      if ok {
+        ops[k] = true
+      }
+    }
+    if rep.Failed = setKeys(ops); len(rep.Failed) == 0 {
+      clearSyncReport(key, rep)
+    }
+  }
+
+  /* Whatever the reports did themselves is not to be reported */
+  TakeCalls()
+}
+
+/* The comment is public: it says which changes failed and the status, the rest stays in the logs */
+func syncReportMessage(calls []CallResult, event string) string {
+  lines := make([]string, 0, len(calls))
+  seen := NewSet()
+  for _, v := range calls {
+    line := "- " + describeOperation(operationOf(v)) + ": " + describeError(v.Err)
+    if !seen[line] {
+      seen[line] = true
+      lines = append(lines, line)
+    }
+  }
+
+  message := "trellohub couldn't sync some of the changes here, they might be missing on the other side:\n\n" +
+    strings.Join(lines, "\n")
+  if len(event) > 0 {
+    message += fmt.Sprintf("\n\nThe details are in its logs under event `%s`.", event)
+  }
+  return message + "\n\nThis goes away once the same changes go through."
+}
+
+func postSyncReport(key string, ent *syncEntity, calls []CallResult, event string) {
+  Warnf("Sync of %s failed, reporting.", key)
+  ops := make([]string, 0, len(calls))
+  for _, v := range calls {
+    ops = append(ops, operationOf(v))
+  }
+  rep := &syncReport{ Failed: ops }
+  message := syncReportMessage(calls, event)
+
+  if ent.card != nil {
+    rep.CardId = ent.card.Id
+    rep.CardComment, _ = ent.card.AddComment(message)
+    ent.card.SetLabel(trello_obj.BoardLabel(trello.SYNC_ERROR_LABEL))
+  }
+  if ent.issue != nil {
+    rep.Issue = ent.issue.String()
+    rep.IssueComment, _ = ent.issue.AddComment(message)
+  }
+  cache.SyncReports[key] = rep
+}
+
+func clearSyncReport(key string, rep *syncReport) {
+  Infof("Sync of %s works again, clearing the report.", key)
+  if len(rep.CardId) > 0 {
+    card := trello_obj.GetCard(rep.CardId)
+    if len(rep.CardComment) > 0 {
+      card.DelComment(rep.CardComment)
+    }
+    if labelid := trello_obj.GetLabel(trello.SYNC_ERROR_LABEL); len(labelid) > 0 {
+      card.DelLabel(labelid)
+    }
+  }
+  if issue := issueBySpec(rep.Issue); issue != nil && rep.IssueComment != 0 {
+    issue.DelComment(rep.IssueComment)
+  }
+  delete(cache.SyncReports, key)
+}
+
+/* owner/repo#N back to the issue, nil if it's not one */
+func issueBySpec(spec string) *github.Issue {
+  if res := regexp.MustCompile("^" + REGEX_GH_OWNREPO + "#([0-9]+)$").FindStringSubmatch(spec); res != nil {
+    issueno, _ := strconv.Atoi(res[len(res) - 1])
+    return github_obj.GetIssue(res[1], issueno)
+  }
+  return nil
+}
+
+/* Members of the set, in order */
+func setKeys(set Set) []string {
+  res := make([]string, 0, len(set))
+  for k, ok := range set {
+    if ok {
+      res = append(res, k)
+    }
+  }
+  sort.Strings(res)
+  return res
+}
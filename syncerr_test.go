@@ -0,0 +1,75 @@
+package main
+
+import (
+  "errors"
+  "testing"
+  . "github.com/ErintLabs/trellohub/genapi"
+)
+
+/* Only the status and the text matter for the reports */
+func apiError(status int, text string) *APIError {
+  return &APIError{ Method: "PUT", Query: "/cards/1/idList", Status: status, Text: text }
+}
+
+func TestDescribeOperation(t *testing.T) {
+  cases := []struct {
+    op      string
+    res     string
+  }{
+    { "PUT /cards/5a1b/idList", "moving the card" },
+    { "POST /cards/5a1b/idLabels", "adding a label to the card" },
+    { "DELETE /cards/5a1b/idLabels/77", "removing a label from the card" },
+    { "POST /checklists/9/checkItems", "updating the checklist of the card" },
+    { "PUT /cards/5a1b/checklist/9/checkItem/1/state", "updating the checklist of the card" },
+    { "POST repos/owner/repo/issues/12/labels", "adding a label to the issue" },
+    { "DELETE repos/owner/repo/issues/12/labels/in%20review", "removing label `in review` from the issue" },
+    { "DELETE repos/owner/repo/issues/12/labels/a%60b", "removing label `?` from the issue" },
+    { "PATCH repos/owner/repo/issues/12", "updating the issue" },
+    { "POST repos/owner/repo/hooks", "another change" },
+  }
+
+  for _, c := range cases {
+    if res := describeOperation(c.op); res != c.res {
+      t.Errorf("%q: got %q, want %q", c.op, res, c.res)
+    }
+  }
+}
+
+func TestDescribeError(t *testing.T) {
+  cases := []struct {
+    err     error
+    res     string
+  }{
+    { apiError(422, `{"message":"Validation Failed","documentation_url":"https://docs.github.com/rest"}`), "422 Validation Failed" },
+    { apiError(400, "invalid value for idList"), "400 invalid value for idList" },
+    { apiError(401, "see https://trello.com/app-key"), "401 Unauthorized" },
+    { apiError(500, ""), "500 Internal Server Error" },
+    { apiError(0, "dial tcp: i/o timeout"), "no answer" },
+    { errors.New("something"), "failed" },
+  }
+
+  for _, c := range cases {
+    if res := describeError(c.err); res != c.res {
+      t.Errorf("%v: got %q, want %q", c.err, res, c.res)
+    }
+  }
+}
+
+func TestSyncReportMessage(t *testing.T) {
+  failed := apiError(422, `{"message":"Validation Failed"}`)
+  calls := []CallResult{
+    { Method: "POST", Query: "repos/owner/repo/issues/1/labels", Err: failed, Event: "e1" },
+    { Method: "POST", Query: "repos/owner/repo/issues/1/labels", Err: failed, Event: "e1" },
+    { Method: "PUT", Query: "/cards/1/idList?value=2", Err: apiError(0, "timeout"), Event: "e1" },
+  }
+
+  res := syncReportMessage(calls, "e1")
+  want := "trellohub couldn't sync some of the changes here, they might be missing on the other side:\n\n" +
+    "- adding a label to the issue: 422 Validation Failed\n" +
+    "- moving the card: no answer\n\n" +
+    "The details are in its logs under event `e1`.\n\n" +
+    "This goes away once the same changes go through."
+  if res != want {
+    t.Errorf("got\n%s\nwant\n%s", res, want)
+  }
+}
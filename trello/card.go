@@ -5,7 +5,6 @@ import (
   . "github.com/ErintLabs/trellohub/genapi"
   "github.com/ErintLabs/trellohub/github"
   "net/url"
-  "log"
   "strconv"
   "regexp"
   . "time" // what the fug? without direct import it complains
@@ -14,9 +13,12 @@ import (
 type Card struct {
   // Object TODO cascading
   Id          string        `json:"id"`
+  ShortLink   string        `json:"shortLink"`
+  BoardId     string        `json:"idBoard"`
   Name        string        `json:"name"`
   ListId      string        `json:"idList"`
   Desc        string        `json:"desc"`
+  Labels      []string      `json:"idLabels"`
   trello      *Trello
   Issue       *github.Issue `json:"-"`
   Checklist   *Checklist    `json:"-"`
@@ -26,10 +28,13 @@ type Card struct {
 /* Places the card in the cache */
 func (card *Card) cache() {
   card.trello.cardById[card.Id] = card
+  if len(card.ShortLink) > 0 {
+    card.trello.cardByLink[card.ShortLink] = card
+  }
   if card.Issue != nil {
     issuestr := card.Issue.String()
     if card.trello.cardByIssue[issuestr] != card {
-      log.Printf("Card %s registered for issue %s", card.Name, issuestr)
+      Infof("Card %s registered for issue %s", card.Name, issuestr)
       card.trello.cardByIssue[issuestr] = card
     }
   }
@@ -45,12 +50,12 @@ func (card *Card) load() {
   GenGET(card.trello, "/cards/" + card.Id + "/attachments", &data)
   issuesFound := 0
   for _, v := range data {
-    log.Printf("Found attachment: %s", v.Name)
+    Debugf("Found attachment: %s", v.Name)
     re := regexp.MustCompile(REGEX_GH_ISSUE)
     if res := re.FindStringSubmatch(v.Name); res != nil {
       issuesFound ++;
       if issuesFound > 1 {
-        log.Printf("WARNING: Duplicate issue attachments found on card #%s.", card.Id)
+        Warnf("Duplicate issue attachments found on card #%s.", card.Id)
       } else {
         issueno, _ := strconv.Atoi(res[2])
         card.LinkIssue(card.trello.github.GetIssue(res[1], issueno))
@@ -62,6 +67,13 @@ func (card *Card) load() {
   card.LoadChecklists()
 }
 
+/* Forces the card to be re-read from the server */
+func (card *Card) Reload() {
+  Infof("Reloading card %s.", card.Id)
+  card.load()
+  card.cache()
+}
+
 /* Adds a card to the list with a given name and returns the card id */
 func (trello *Trello) AddCard(listid string, name string, desc string) *Card {
   data := &Card{ trello: trello, Members: NewSet() }
@@ -95,6 +107,48 @@ func (card *Card) attachURL(addr string) {
   // TODO if error
 }
 
+type Attachment struct {
+  Object
+  URL     string    `json:"url"`
+}
+
+/* Lists what is attached to the card at the moment */
+func (card *Card) Attachments() []Attachment {
+  var data []Attachment
+  GenGET(card.trello, "/cards/" + card.Id + "/attachments", &data)
+  return data
+}
+
+/* Attaches the URLs unless they are there already */
+func (card *Card) AttachOnce(addrs ...string) {
+  if len(addrs) == 0 {
+    return
+  }
+
+  known := NewSet()
+  for _, v := range card.Attachments() {
+    known[v.URL] = true
+  }
+
+  for _, v := range addrs {
+    if !known[v] {
+      known[v] = true
+      Infof("Attaching %s to card %s.", v, card.Id)
+      card.attachURL(v)
+    }
+  }
+}
+
+/* Removes all attachments with the URL */
+func (card *Card) DetachURL(addr string) {
+  for _, v := range card.Attachments() {
+    if v.URL == addr {
+      Infof("Detaching %s from card %s.", addr, card.Id)
+      GenDEL(card.trello, "/cards/" + card.Id + "/attachments/" + v.Id)
+    }
+  }
+}
+
 func (card *Card) AttachIssue(issue *github.Issue) {
   card.attachURL(issue.IssueURL())
   /* We don't have a change to wait until the update, add up instantly */
@@ -102,10 +156,31 @@ func (card *Card) AttachIssue(issue *github.Issue) {
   card.cache()
 }
 
+var cardMoves = NewCounter("trellohub_cards_moved_total", "Cards moved by trellohub, by the target list.", "list")
+
 /* Move a card to the different list */
-func (card *Card) Move(listid string) {
-  log.Printf("Moving card %s to list %s.", card.Id, listid)
-  GenPUT(card.trello, "/cards/" + card.Id + "/idList?value=" + listid)
+func (card *Card) Move(listid string) error {
+  Infof("Moving card %s to list %s.", card.Id, listid)
+  if err := GenPUT(card.trello, "/cards/" + card.Id + "/idList?value=" + listid); err != nil {
+    return err
+  }
+  cardMoves.Inc(card.trello.Lists.NameOf(listid))
+  /* Don't wait for the hook, next move in the same event has to know */
+  card.ListId = listid
+  return nil
+}
+
+/* Leave a comment on the card, returns its id */
+func (card *Card) AddComment(text string) (string, error) {
+  Infof("Commenting on card %s.", card.Id)
+  data := Object{}
+  err := GenPOSTForm(card.trello, "/cards/" + card.Id + "/actions/comments", &data, url.Values{ "text": { text } })
+  return data.Id, err
+}
+
+func (card *Card) DelComment(commentid string) error {
+  Infof("Deleting comment %s from card %s.", commentid, card.Id)
+  return GenDEL(card.trello, "/actions/" + commentid)
 }
 
 /* Find card by Issue. Assuming only one such card exists. */
@@ -113,10 +188,55 @@ func (trello *Trello) FindCard(issue string) *Card {
   return trello.cardByIssue[issue]
 }
 
+/* Issue to card id correspondence as we know it */
+func (trello *Trello) Links() map[string]string {
+  res := make(map[string]string)
+  for k, v := range trello.cardByIssue {
+    res[k] = v.Id
+  }
+  return res
+}
+
+/* Which of our cards a request to Trello was about, nil if none or we don't know it */
+func (trello *Trello) CardByQuery(query string) *Card {
+  if res := regexp.MustCompile("^/cards?/([0-9a-fA-F]{24})").FindStringSubmatch(query); res != nil {
+    return trello.cardById[res[1]]
+  }
+  if res := regexp.MustCompile("^/checklists/([0-9a-fA-F]{24})").FindStringSubmatch(query); res != nil {
+    for _, v := range trello.cardById {
+      if v.Checklist != nil && v.Checklist.Id == res[1] {
+        return v
+      }
+    }
+  }
+  return nil
+}
+
+/* Find card by its short link, nil if it's not on our board */
+func (trello *Trello) FindCardByLink(link string) *Card {
+  if card := trello.cardByLink[link]; card != nil {
+    return card
+  }
+
+  /* Trello accepts short links in place of ids; loading caches the card, so checking the board first */
+  data := Card{}
+  if err := GenGET(trello, "/cards/" + link + "?fields=id,shortLink,idBoard", &data); err != nil {
+    Warnf("Can't find card %s: %s", link, err)
+    return nil
+  }
+  if data.ShortLink != link || data.BoardId != trello.BoardId {
+    Warnf("Card %s is not on our board.", link)
+    return nil
+  }
+  return trello.GetCard(data.Id)
+}
+
 /* Fetch all cards from the board and [re-]initialise caches */
-func (trello *Trello) makeCardCache() {
+func (trello *Trello) makeCardCache() error {
   var data []Card
-  GenGET(trello, "/boards/" + trello.BoardId + "/cards", &data)
+  if err := GenGET(trello, "/boards/" + trello.BoardId + "/cards", &data); err != nil {
+    return err
+  }
 
   for _, v := range data {
     card := new(Card)
@@ -127,9 +247,10 @@ func (trello *Trello) makeCardCache() {
 
     // TODO implement a more sophisticated rate limit evasion mechanism
     // for now it's ok
-    log.Printf("Sleeping 1 second")
+    Debugf("Sleeping 1 second")
     Sleep(1 * Second)
   }
+  return nil
 }
 
 /* Attach an Issue link */
@@ -141,12 +262,12 @@ func (card *Card) LinkIssue(issue *github.Issue) {
 }
 
 /* Update name/description */
-func (card *Card) UpdateName(newname string) {
-  GenPUT(card.trello, "/cards/" + card.Id + "/name?value=" + url.QueryEscape(newname))
+func (card *Card) UpdateName(newname string) error {
+  return GenPUT(card.trello, "/cards/" + card.Id + "/name?value=" + url.QueryEscape(newname))
 }
 
-func (card *Card) UpdateDesc(newdesc string) {
-  GenPUT(card.trello, "/cards/" + card.Id + "/desc?value=" + url.QueryEscape(newdesc))
+func (card *Card) UpdateDesc(newdesc string) error {
+  return GenPUT(card.trello, "/cards/" + card.Id + "/desc?value=" + url.QueryEscape(newdesc))
 }
 
 /* TODO handlers:
@@ -3,7 +3,6 @@ package trello
 
 import (
   . "github.com/ErintLabs/trellohub/genapi"
-  "log"
   "net/url"
   "fmt"
 )
@@ -55,16 +54,16 @@ func (checklist *Checklist) EnsureOrder() {
   GenGET(checklist.card.trello, "/checklists/" + checklist.Id + "/checkItems", &data)
   for i, v := range checklist.Items {
     if i >= len(data) {
-      log.Printf("[BUG] Internal representation of checklist has more items than on server")
+      Errorf("Internal representation of checklist has more items than on server")
     } else if data[i].Id != v.Id {
-      log.Printf("[BUG] Wrong order delivery detected at position %d", i)
+      Errorf("Wrong order delivery detected at position %d", i)
     }
   }
 }
 
 /* Add a checklist to the card and return the id */
 func (card *Card) AddChecklist() *Checklist {
-  log.Printf("Adding a checklist to the card %s.", card.Id)
+  Infof("Adding a checklist to the card %s.", card.Id)
   card.NewChecklist()
   GenPOSTForm(card.trello, "/cards/" + card.Id + "/checklists", card.Checklist, url.Values{})
 
@@ -73,7 +72,7 @@ func (card *Card) AddChecklist() *Checklist {
 
 /* Add an item to the checklist and returns id */
 func (checklist *Checklist) PostToChecklist(itm CheckItem) string {
-  log.Printf("Adding checklist item: %s.", itm.Text)
+  Infof("Adding checklist item: %s.", itm.Text)
   var checkedTxt string
   if itm.Checked {
     checkedTxt = "true"
@@ -87,33 +86,34 @@ func (checklist *Checklist) PostToChecklist(itm CheckItem) string {
 }
 
 /* Updates an item state */
-func (checklist *Checklist) UpdateItemName(i int, newname string) {
-  log.Printf("Updating checklist item %d with new name %s.", i, newname)
-  GenPUT(checklist.card.trello, "/cards/" + checklist.card.Id + "/checklist/" + checklist.Id +
+func (checklist *Checklist) UpdateItemName(i int, newname string) error {
+  Infof("Updating checklist item %d with new name %s.", i, newname)
+  return GenPUT(checklist.card.trello, "/cards/" + checklist.card.Id + "/checklist/" + checklist.Id +
     "/checkItem/" + checklist.in2id[i] + "/name?value=" + url.QueryEscape(newname))
 }
 
-func (checklist *Checklist) UpdateItemState(i int, newstate bool) {
+func (checklist *Checklist) UpdateItemState(i int, newstate bool) error {
   statestr := "incomplete"
   if newstate {
     statestr = "complete"
   }
-  log.Printf("Updating checklist item %d with new state %s.", i, statestr)
-  GenPUT(checklist.card.trello, "/cards/" + checklist.card.Id + "/checklist/" + checklist.Id +
+  Infof("Updating checklist item %d with new state %s.", i, statestr)
+  return GenPUT(checklist.card.trello, "/cards/" + checklist.card.Id + "/checklist/" + checklist.Id +
     "/checkItem/" + checklist.in2id[i] + "/state?value=" + url.QueryEscape(statestr))
 }
 
 /* Remove a checkitem */
-func (checklist *Checklist) DelItem(i int) {
-  log.Printf("Deleting checklist item %d.", i)
-  GenDEL(checklist.card.trello, "/checklists/" + checklist.Id + "/checkItems/" + checklist.in2id[i])
+func (checklist *Checklist) DelItem(i int) error {
+  Infof("Deleting checklist item %d.", i)
+  return GenDEL(checklist.card.trello, "/checklists/" + checklist.Id + "/checkItems/" + checklist.in2id[i])
 }
 
 /* Remove whole checklist */
-func (card *Card) DelChecklist() {
+func (card *Card) DelChecklist() error {
   if card.Checklist != nil {
-    GenDEL(card.trello, "/card/" + card.Id + "/checklists/" + card.Checklist.Id)
+    return GenDEL(card.trello, "/card/" + card.Id + "/checklists/" + card.Checklist.Id)
   }
+  return nil
 }
 
 /* Add an item to checklist, note must have an Id */
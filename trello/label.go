@@ -3,23 +3,35 @@ package trello
 
 import (
   . "github.com/ErintLabs/trellohub/genapi"
-  "log"
   "net/url"
+  "regexp"
 )
 
+/* Colors for new labels, in order */
+var labelColors = [...]string { "green", "yellow", "orange", "red", "purple", "blue", "sky", "lime", "pink", "black" }
+
+/* Boards come with six labels, so those take the first six colors; boards which have less start over */
+func labelColor(count int) string {
+  if count < 6 {
+    count += len(labelColors)
+  }
+  return labelColors[(count - 6) % len(labelColors)]
+}
+
 /* Add a label to board */
 func (trello *Trello) AddLabel(name string) string {
   /* Pick up a color first */
-  colors := [...]string { "green", "yellow", "orange", "red", "purple", "blue", "sky", "lime", "pink", "black" }
-
   var labels []Object
   GenGET(trello, "/boards/" + trello.BoardId + "/labels/", &labels)
 
   /* TODO: avoid duplicates too */
 
   /* Create a label with appropriate color */
-  col := colors[ (len(labels)-6) % len(colors) ]
-  log.Printf("Creating a new %s label name %s in Trello.", col, name)
+  return trello.addLabelColored(name, labelColor(len(labels)))
+}
+
+func (trello *Trello) addLabelColored(name string, col string) string {
+  Infof("Creating a new %s label name %s in Trello.", col, name)
   data := Object{}
   GenPOSTForm(trello, "/labels/", &data, url.Values{
     "name": { name },
@@ -31,21 +43,80 @@ func (trello *Trello) AddLabel(name string) string {
   return data.Id
 }
 
+/* Gets one of BoardLabels, creating it if it's missing */
+func (trello *Trello) BoardLabel(name string) string {
+  if id := trello.GetLabel(name); len(id) > 0 {
+    return id
+  }
+  for _, v := range BoardLabels {
+    if v.Name == name {
+      return trello.addLabelColored(name, v.Color)
+    }
+  }
+  return trello.AddLabel(name)
+}
+
 /* Attach a label to the card */
-func (card *Card) SetLabel(labelid string) {
-    GenPOSTForm(card.trello, "/cards/" + card.Id + "/idLabels", nil, url.Values{ "value": { labelid } })
+func (card *Card) SetLabel(labelid string) error {
+  /* Trello doesn't like duplicates */
+  for _, v := range card.Labels {
+    if v == labelid {
+      return nil
+    }
+  }
+
+  if err := GenPOSTForm(card.trello, "/cards/" + card.Id + "/idLabels", nil, url.Values{ "value": { labelid } }); err != nil {
+    return err
+  }
+  card.Labels = append(card.Labels, labelid)
+  return nil
+}
+
+/* And take it off */
+func (card *Card) DelLabel(labelid string) error {
+  for i, v := range card.Labels {
+    if v == labelid {
+      if err := GenDEL(card.trello, "/cards/" + card.Id + "/idLabels/" + labelid); err != nil {
+        return err
+      }
+      card.Labels = append(card.Labels[:i], card.Labels[i+1:]...)
+      return nil
+    }
+  }
+  return nil
+}
+
+/* Takes note of a label put on or taken off the card on Trello, so that the ones above know what's there */
+func (card *Card) NoteLabel(labelid string, on bool) {
+  for i, v := range card.Labels {
+    if v == labelid {
+      if !on {
+        card.Labels = append(card.Labels[:i], card.Labels[i+1:]...)
+      }
+      return
+    }
+  }
+  if on {
+    card.Labels = append(card.Labels, labelid)
+  }
 }
 
 /* Build a repo to label correspondence cache */
 func (trello *Trello) makeLabelCache() bool {
+  trello.loadLabels()
+  return true // needed for dirty magic
+}
+
+func (trello *Trello) loadLabels() error {
   var labels []Object
-  GenGET(trello, "/boards/" + trello.BoardId + "/labels/", &labels)
+  if err := GenGET(trello, "/boards/" + trello.BoardId + "/labels/", &labels); err != nil {
+    return err
+  }
 
   for _, v := range labels {
     trello.labelCache[v.Name] = v.Id
   }
-
-  return true // needed for dirty magic
+  return nil
 }
 
 /* Get the label id or empty string if not found */
@@ -61,3 +132,24 @@ func (trello *Trello) GetLabel(repoid string) string {
   /* If we are still there, something's wrong */
   return ""
 }
+
+/* Lists the repositories we serve, which are the labels named like one */
+func (trello *Trello) Repos() []string {
+  res := make([]string, 0)
+  re := regexp.MustCompile("^" + REGEX_GH_OWNREPO + "$")
+  for k := range trello.labelCache {
+    if re.MatchString(k) {
+      res = append(res, k)
+    }
+  }
+  return res
+}
+
+/* Same, along with the label ids */
+func (trello *Trello) RepoLabels() map[string]string {
+  res := make(map[string]string)
+  for _, v := range trello.Repos() {
+    res[v] = trello.labelCache[v]
+  }
+  return res
+}
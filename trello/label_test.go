@@ -0,0 +1,48 @@
+package trello
+
+import (
+  "reflect"
+  "testing"
+)
+
+func TestLabelColor(t *testing.T) {
+  cases := []struct {
+    count   int
+    color   string
+  }{
+    { 0, "purple" },
+    { 5, "black" },
+    { 6, "green" },
+    { 7, "yellow" },
+    { 15, "black" },
+    { 16, "green" },
+  }
+
+  for _, c := range cases {
+    if color := labelColor(c.count); color != c.color {
+      t.Errorf("%d labels: got %s, want %s", c.count, color, c.color)
+    }
+  }
+}
+
+func TestNoteLabel(t *testing.T) {
+  cases := []struct {
+    labels  []string
+    label   string
+    on      bool
+    res     []string
+  }{
+    { []string{}, "a", true, []string{ "a" } },
+    { []string{ "a" }, "a", true, []string{ "a" } },
+    { []string{ "a", "b" }, "a", false, []string{ "b" } },
+    { []string{ "b" }, "a", false, []string{ "b" } },
+  }
+
+  for _, c := range cases {
+    card := &Card{ Labels: append([]string{}, c.labels...) }
+    card.NoteLabel(c.label, c.on)
+    if !reflect.DeepEqual(card.Labels, c.res) {
+      t.Errorf("%v with %s %v: got %v, want %v", c.labels, c.label, c.on, card.Labels, c.res)
+    }
+  }
+}
@@ -0,0 +1,256 @@
+/* Setting the board up for the workflow, without breaking what is there already */
+package trello
+
+import (
+  "fmt"
+  "net/url"
+  "strconv"
+  "strings"
+  "unicode"
+  . "github.com/ErintLabs/trellohub/genapi"
+  "github.com/ErintLabs/trellohub/github"
+)
+
+/* A list of the workflow, Key is what $LISTS calls it, Label is what the issues of its cards get on GitHub */
+type WorkflowList struct {
+  Key     string
+  Name    string
+  Label   github.LabelSpec
+}
+
+/* The workflow in the order of the board, the labels are the defaults for $WORKFLOW_LABELS */
+var Workflow = []WorkflowList {
+  { "repos", "📋 Repositories", github.LabelSpec{} },
+  { "inbox", "📥 Inbox", github.LabelSpec{ Name: "inbox", Color: "ededed", Description: "New, nobody is on it yet" } },
+  { "works", "🚧 In Works", github.LabelSpec{ Name: "work", Color: "fbca04", Description: "Being worked on" } },
+  { "block", "🚫 Blocked", github.LabelSpec{ Name: "block", Color: "b60205", Description: "Waiting for something else" } },
+  { "review", "📝 Awaiting Review", github.LabelSpec{ Name: "review", Color: "1d76db", Description: "Waiting for a review" } },
+  { "merged", "💾 Merged to Mainline", github.LabelSpec{ Name: "merged", Color: "5319e7", Description: "Merged, not deployed yet" } },
+  { "deploy", "📲 Deployed on Test", github.LabelSpec{ Name: "deploy", Color: "c5def5", Description: "Deployed for testing" } },
+  { "tested", "📱 Tested", github.LabelSpec{ Name: "test", Color: "bfd4f2", Description: "Tested, waiting for acceptance" } },
+  { "accept", "📤 Accepted", github.LabelSpec{ Name: "done", Color: "0e8a16", Description: "Accepted" } },
+}
+
+/* Changes the GitHub labels of the lists by their keys, only the fields given are changed */
+func ConfigureLabels(labels map[string]github.LabelSpec) error {
+  for key, spec := range labels {
+    found := false
+    for i := range Workflow {
+      if Workflow[i].Key != key || len(Workflow[i].Label.Name) == 0 {
+        continue
+      }
+      found = true
+      if len(spec.Name) > 0 {
+        Workflow[i].Label.Name = spec.Name
+      }
+      if len(spec.Color) > 0 {
+        Workflow[i].Label.Color = strings.TrimPrefix(spec.Color, "#")
+      }
+      if len(spec.Description) > 0 {
+        Workflow[i].Label.Description = spec.Description
+      }
+    }
+    if !found {
+      return fmt.Errorf("%s is not a list with a label", key)
+    }
+  }
+  return nil
+}
+
+/* GitHub labels of the workflow */
+func WorkflowLabels() []github.LabelSpec {
+  res := make([]github.LabelSpec, 0, len(Workflow))
+  for _, v := range Workflow {
+    if len(v.Label.Name) > 0 {
+      res = append(res, v.Label)
+    }
+  }
+  return res
+}
+
+/* Trello label put on cards which didn't sync */
+const SYNC_ERROR_LABEL = "⚠ sync error"
+
+type BoardLabel struct {
+  Name    string
+  Color   string
+}
+
+/* Labels trellohub puts on cards by itself, repositories get theirs when registered */
+var BoardLabels = []BoardLabel {
+  { SYNC_ERROR_LABEL, "red" },
+}
+
+/* What provisioning is going to do, or did */
+type ProvisionStep struct {
+  Action  string  // keep, create, reopen, move, label, recolor
+  Key     string
+  Name    string
+  Id      string
+  Pos     float64
+  Color   string
+}
+
+func (step *ProvisionStep) String() string {
+  switch step.Action {
+  case "label", "recolor":
+    return fmt.Sprintf("%-8s label %s (%s)", step.Action, step.Name, step.Color)
+  case "create":
+    return fmt.Sprintf("%-8s %-7s %s", step.Action, step.Key, step.Name)
+  }
+  return fmt.Sprintf("%-8s %-7s %s (%s)", step.Action, step.Key, step.Name, step.Id)
+}
+
+type boardList struct {
+  Object
+  Closed  bool      `json:"closed"`
+  Pos     float64   `json:"pos"`
+}
+
+type boardLabel struct {
+  Object
+  Color   string    `json:"color"`
+}
+
+/* Names are compared without the emojis and the case, "Inbox" is as good as "📥 Inbox" */
+func listNameKey(name string) string {
+  return strings.ToLower(strings.TrimFunc(name, func (r rune) bool {
+    return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+  }))
+}
+
+/* Gap between positions of the lists we create or move */
+const provisionPosStep = 65536
+
+/* Position right after last, halfway to the next list which is in order already, so that it doesn't have to move as well */
+func nextPos(following []*boardList, last float64) float64 {
+  for _, v := range following {
+    if v != nil && v.Pos > last {
+      return last + (v.Pos - last) / 2
+    }
+  }
+  return last + provisionPosStep
+}
+
+/* Compares the board with the workflow, nothing is changed */
+func (trello *Trello) PlanProvision() ([]*ProvisionStep, error) {
+  var lists []boardList
+  if err := GenGET(trello, "/boards/" + trello.BoardId + "/lists?filter=all", &lists); err != nil {
+    return nil, err
+  }
+  var labels []boardLabel
+  if err := GenGET(trello, "/boards/" + trello.BoardId + "/labels", &labels); err != nil {
+    return nil, err
+  }
+  return planProvision(lists, labels), nil
+}
+
+/* The steps from what the board has to the workflow */
+func planProvision(lists []boardList, labels []boardLabel) []*ProvisionStep {
+  /* Open lists win over archived ones with the same name, exact names over similar ones */
+  byName, bySimilarName := make(map[string]*boardList), make(map[string]*boardList)
+  for _, closed := range []bool { true, false } {
+    for i, v := range lists {
+      if v.Closed == closed {
+        byName[v.Name] = &lists[i]
+        bySimilarName[listNameKey(v.Name)] = &lists[i]
+      }
+    }
+  }
+
+  found := make([]*boardList, len(Workflow))
+  for i, wf := range Workflow {
+    if found[i] = byName[wf.Name]; found[i] == nil {
+      found[i] = bySimilarName[listNameKey(wf.Name)]
+    }
+  }
+
+  res := make([]*ProvisionStep, 0)
+  last := 0.0
+  for i, wf := range Workflow {
+    list := found[i]
+    if list == nil {
+      last = nextPos(found[i+1:], last)
+      res = append(res, &ProvisionStep{ Action: "create", Key: wf.Key, Name: wf.Name, Pos: last })
+      continue
+    }
+
+    /* Lists have to follow each other in the workflow order, others may be in between */
+    step := &ProvisionStep{ Action: "keep", Key: wf.Key, Name: list.Name, Id: list.Id, Pos: list.Pos }
+    if list.Pos <= last {
+      last = nextPos(found[i+1:], last)
+      step.Action, step.Pos = "move", last
+    } else {
+      last = list.Pos
+    }
+    if list.Closed {
+      step.Action = "reopen"
+    }
+    res = append(res, step)
+  }
+
+  for _, want := range BoardLabels {
+    step := &ProvisionStep{ Action: "label", Name: want.Name, Color: want.Color }
+    for _, v := range labels {
+      if v.Name == want.Name {
+        step.Id = v.Id
+        if v.Color == want.Color {
+          step = nil
+        } else {
+          step.Action = "recolor"
+        }
+        break
+      }
+    }
+    if step != nil {
+      res = append(res, step)
+    }
+  }
+
+  return res
+}
+
+/* Carries the plan out and fills in the list ids */
+func (trello *Trello) ApplyProvision(plan []*ProvisionStep) error {
+  pos := func (step *ProvisionStep) string {
+    return strconv.FormatFloat(step.Pos, 'f', -1, 64)
+  }
+
+  dic := make(map[string]string)
+  for _, step := range plan {
+    var err error
+    switch step.Action {
+    case "create":
+      data := Object{}
+      err = GenPOSTForm(trello, "/lists/", &data, url.Values{
+        "name": { step.Name },
+        "idBoard": { trello.BoardId },
+        "pos": { pos(step) } })
+      step.Id = data.Id
+    case "reopen":
+      err = GenPUT(trello, "/lists/" + step.Id + "?closed=false&pos=" + pos(step))
+    case "move":
+      err = GenPUT(trello, "/lists/" + step.Id + "/pos?value=" + pos(step))
+    case "label":
+      data := Object{}
+      err = GenPOSTForm(trello, "/labels/", &data, url.Values{
+        "name": { step.Name },
+        "idBoard": { trello.BoardId },
+        "color": { step.Color } })
+      step.Id = data.Id
+    case "recolor":
+      err = GenPUT(trello, "/labels/" + step.Id + "/color?value=" + url.QueryEscape(step.Color))
+    }
+    if err != nil {
+      return fmt.Errorf("%s: %s", step, err)
+    }
+    Infof("Done: %s", step)
+
+    if len(step.Key) > 0 {
+      dic[step.Key] = step.Id
+    }
+  }
+
+  trello.Lists.set(dic)
+  return nil
+}
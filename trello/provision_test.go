@@ -0,0 +1,120 @@
+package trello
+
+import (
+  "testing"
+)
+
+/* The board with every workflow list in place, in order */
+func provisionedBoard() []boardList {
+  res := make([]boardList, 0, len(Workflow))
+  for i, v := range Workflow {
+    res = append(res, boardList{ Object: Object{ Id: v.Key, Name: v.Name }, Pos: float64(i + 1) * 100 })
+  }
+  return res
+}
+
+func TestPlanProvision(t *testing.T) {
+  fine := []boardLabel{ { Object{ "l1", SYNC_ERROR_LABEL }, "red" } }
+
+  similar := provisionedBoard()
+  similar[1].Name = "inbox"
+
+  reopened := provisionedBoard()
+  reopened[2].Closed = true
+
+  /* An archived copy doesn't take the place of the open one */
+  duplicate := append(provisionedBoard(), boardList{ Object: Object{ Id: "old", Name: Workflow[3].Name }, Closed: true, Pos: 50 })
+
+  swapped := provisionedBoard()
+  swapped[4].Pos, swapped[5].Pos = swapped[5].Pos, swapped[4].Pos
+
+  cases := []struct {
+    name    string
+    lists   []boardList
+    labels  []boardLabel
+    steps   map[string]string // key or label name to the action, the rest is kept
+  }{
+    { "empty", nil, nil, map[string]string{ "*": "create", SYNC_ERROR_LABEL: "label" } },
+    { "provisioned", provisionedBoard(), fine, map[string]string{} },
+    { "similar names", similar, fine, map[string]string{} },
+    { "archived", reopened, fine, map[string]string{ "works": "reopen" } },
+    { "archived copy", duplicate, fine, map[string]string{} },
+    { "out of order", swapped, fine, map[string]string{ "merged": "move" } },
+    { "other color", provisionedBoard(), []boardLabel{ { Object{ "l1", SYNC_ERROR_LABEL }, "blue" } }, map[string]string{ SYNC_ERROR_LABEL: "recolor" } },
+    { "other labels", provisionedBoard(), []boardLabel{ { Object{ "l2", "bug" }, "red" } }, map[string]string{ SYNC_ERROR_LABEL: "label" } },
+  }
+
+  for _, c := range cases {
+    plan := planProvision(c.lists, c.labels)
+    labels := 0
+    for _, step := range plan {
+      key := step.Key
+      if len(key) == 0 {
+        key = step.Name
+        labels++
+      }
+      want, ok := c.steps[key]
+      if !ok {
+        want = c.steps["*"]
+      }
+      if len(want) == 0 {
+        want = "keep"
+      }
+      if step.Action != want {
+        t.Errorf("%s: %s", c.name, step)
+      }
+      if step.Action != "create" && step.Action != "label" && len(step.Id) == 0 {
+        t.Errorf("%s: no id in %s", c.name, step)
+      }
+    }
+    if wantLabels := countLabels(c.steps); labels != wantLabels {
+      t.Errorf("%s: %d label steps, want %d", c.name, labels, wantLabels)
+    }
+    if len(plan) - labels != len(Workflow) {
+      t.Errorf("%s: %d list steps, want %d", c.name, len(plan) - labels, len(Workflow))
+    }
+  }
+}
+
+func countLabels(steps map[string]string) int {
+  if _, ok := steps[SYNC_ERROR_LABEL]; ok {
+    return 1
+  }
+  return 0
+}
+
+/* Lists keep their positions unless they have to move, and then they go in between the neighbours */
+func TestPlanProvisionPositions(t *testing.T) {
+  moved := provisionedBoard()
+  moved[4].Pos = 50
+
+  missing := provisionedBoard()
+  missing = append(missing[:4], missing[5:]...)
+
+  last := len(Workflow) - 1
+  tail := provisionedBoard()[:last]
+
+  cases := []struct {
+    name    string
+    lists   []boardList
+    i       int
+    pos     float64
+  }{
+    { "moved", moved, 4, 500 },
+    { "created", missing, 4, 500 },
+    { "created last", tail, last, float64(last) * 100 + provisionPosStep },
+  }
+
+  for _, c := range cases {
+    plan := planProvision(c.lists, nil)
+    if plan[c.i].Pos != c.pos {
+      t.Errorf("%s: got %s at %v, want %v", c.name, plan[c.i], plan[c.i].Pos, c.pos)
+    }
+    /* Nothing else has to move */
+    for i, step := range plan[:len(Workflow)] {
+      if i != c.i && step.Action != "keep" {
+        t.Errorf("%s: %s", c.name, step)
+      }
+    }
+  }
+}
@@ -4,7 +4,7 @@ import (
   . "github.com/ErintLabs/trellohub/genapi"
   "github.com/ErintLabs/trellohub/github"
   "net/url"
-  "log"
+  "encoding/json"
 )
 
 // TODO: handle error responces from Trello
@@ -22,12 +22,65 @@ type ListRef struct {
   AcceptId  string    `json:"accept"`
 }
 
+/* Workflow order of the lists by their key in $LISTS, automatic moves only go forward */
+var ListRanks = map[string]int {
+  "inbox": 0,
+  "works": 1,
+  "block": 1,
+  "review": 2,
+  "merged": 3,
+  "deploy": 4,
+  "tested": 5,
+  "accept": 6,
+}
+
+func (lists *ListRef) dic() map[string]string {
+  var dic map[string]string
+  data, _ := json.Marshal(lists)
+  json.Unmarshal(data, &dic)
+  return dic
+}
+
+/* The opposite of dic() */
+func (lists *ListRef) set(dic map[string]string) {
+  data, _ := json.Marshal(dic)
+  json.Unmarshal(data, lists)
+}
+
+/* Resolves a list by its key in $LISTS (e.g. "merged"), empty string if unknown */
+func (lists *ListRef) ByName(name string) string {
+  return lists.dic()[name]
+}
+
+/* The opposite, empty string if not one of ours */
+func (lists *ListRef) NameOf(listid string) string {
+  if len(listid) > 0 {
+    for k, v := range lists.dic() {
+      if v == listid {
+        return k
+      }
+    }
+  }
+  return ""
+}
+
+/* Position of the list in the workflow, -1 if it's not part of it */
+func (lists *ListRef) Rank(listid string) int {
+  if rank, ok := ListRanks[lists.NameOf(listid)]; ok {
+    return rank
+  }
+  return -1
+}
+
 type Payload struct {
   Action      struct {
     Type      string        `json:"type"`
+    Member    tUser         `json:"member"`
     Data      struct {
       Member  string        `json:"idMember"`
+      Added   string        `json:"idMemberAdded"`
       List    Object        `json:"list"`
+      Label   Object        `json:"label"`
       ChList  Checklist     `json:"checklist"`
       ChItem  CheckItem     `json:"checkItem"`
       Card    Card          `json:"card"`
@@ -38,6 +91,7 @@ type Payload struct {
       ListB   Object        `json:"listBefore"`
       ListA   Object        `json:"listAfter"`
       Attach  struct {
+        Name  string        `json:"name"`
         URL   string        `json:"url"`
       }                     `json:"attachment"`
     }                       `json:"data"`
@@ -54,16 +108,21 @@ type Trello struct {
   Token string
   Key string
   BoardId string
+  boardRef string // as given, in case the id couldn't be resolved at first
   Lists ListRef
   github *github.GitHub
+  OnLoaded func (cache string, err error)
+  OnRenamed func (oldname string, newname string)
 
   /* RenameThese to make sense */
   labelCache map[string]string
   userIdbyName map[string]string
   userNamebyId map[string]string
+  userFullbyName map[string]string
 
   cardById      map[string]*Card
   cardByIssue   map[string]*Card
+  cardByLink    map[string]*Card
 }
 
 func New(key string, token string, boardid string) *Trello {
@@ -71,24 +130,44 @@ func New(key string, token string, boardid string) *Trello {
   t.Token = token
   t.Key = key
 
+  t.boardRef = boardid
   t.BoardId = t.getFullBoardId(boardid)
 
   return t
 }
 
+/* Startup reports each cache once it's loaded, if anybody cares */
+func (trello *Trello) loaded(name string, err error) {
+  if trello.OnLoaded != nil {
+    trello.OnLoaded(name, err)
+  }
+}
+
 func (trello *Trello) Startup(github *github.GitHub) {
   trello.github = github
 
   trello.labelCache = make(map[string]string)
-  trello.makeLabelCache()
+  trello.loaded("labels", trello.loadLabels())
 
-  /* Note: we assume users don't change anyway so we only do trello at startup */
+  /* Changes come from the hook, see AddMember and co */
   trello.userIdbyName = make(map[string]string)
-  trello.makeUserCache()
+  trello.userFullbyName = make(map[string]string)
+  trello.loaded("users", trello.loadUsers())
 
   trello.cardById = make(map[string]*Card)
   trello.cardByIssue = make(map[string]*Card)
-  trello.makeCardCache()
+  trello.cardByLink = make(map[string]*Card)
+  trello.loaded("cards", trello.makeCardCache())
+}
+
+/* Number of entries in each of the caches */
+func (trello *Trello) CacheSizes() map[string]int {
+  return map[string]int {
+    "trello_labels": len(trello.labelCache),
+    "trello_users": len(trello.userIdbyName),
+    "trello_cards": len(trello.cardById),
+    "trello_links": len(trello.cardByIssue),
+  }
 }
 
 func (trello *Trello) AuthQuery() string {
@@ -105,24 +184,36 @@ func (trello *Trello) getFullBoardId(boardid string) string {
   return data.Id
 }
 
-type webhookInfo struct {
-  Id    string    `json:"id"`
-  Model string    `json:"idModel"`
-  URL   string    `json:"callbackURL"`
+type WebhookInfo struct {
+  Id        string    `json:"id"`
+  Model     string    `json:"idModel"`
+  URL       string    `json:"callbackURL"`
+  Active    bool      `json:"active"`
+  Failures  int       `json:"consecutiveFailures"`
+  FailedAt  string    `json:"firstConsecutiveFailDate"`
+}
+
+/* Lists the webhooks installed with our token */
+func (trello *Trello) Hooks() ([]WebhookInfo, error) {
+  var data []WebhookInfo
+  err := GenGET(trello, "/token/" + trello.Token + "/webhooks/", &data)
+  return data, err
 }
 
 /* Checks that a webhook is installed over the board, in case it isn't creates one */
-func (trello *Trello) EnsureHook(callbackURL string) {
+func (trello *Trello) EnsureHook(callbackURL string) error {
   /* Check if we have a hook already */
-  var data []webhookInfo
-  GenGET(trello, "/token/" + trello.Token + "/webhooks/", &data)
+  data, err := trello.Hooks()
+  if err != nil {
+    return err
+  }
   found := false
 
   for _, v := range data {
     /* Check if we have a hook for our own URL at same model */
     if v.Model == trello.BoardId {
       if v.URL == callbackURL {
-        log.Print("Hook found, nothing to do here.")
+        Infof("Hook found, nothing to do here.")
         found = true
         break
       }
@@ -132,13 +223,28 @@ func (trello *Trello) EnsureHook(callbackURL string) {
   /* If not, install one */
   if !found {
     /* TODO: save hook reference and uninstall maybe? */
-    GenPOSTForm(trello, "/webhooks/", nil, url.Values{
+    if err := GenPOSTForm(trello, "/webhooks/", nil, url.Values{
       "name": { "trellohub for " + trello.BoardId },
       "idModel": { trello.BoardId },
-      "callbackURL": { callbackURL } })
+      "callbackURL": { callbackURL } }); err != nil {
+      return err
+    }
 
-    log.Print("Webhook installed.")
+    Infof("Webhook installed.")
   } else {
-    log.Print("Reusing existing webhook.")
+    Infof("Reusing existing webhook.")
+  }
+  return nil
+}
+
+/* Checks that the key and the token are good for the board */
+func (trello *Trello) CheckToken() error {
+  if len(trello.BoardId) == 0 {
+    data := Object{}
+    if err := GenGET(trello, "/boards/" + trello.boardRef, &data); err != nil {
+      return err
+    }
+    trello.BoardId = data.Id
   }
+  return GenGET(trello, "/boards/" + trello.BoardId + "?fields=id", nil)
 }
@@ -3,13 +3,13 @@ package trello
 
 import (
   . "github.com/ErintLabs/trellohub/genapi"
-  "log"
   "net/url"
 )
 
 type tUser struct {
-  Name  string    `json:"username"`
-  Id    string    `json:"id"`
+  Name      string    `json:"username"`
+  FullName  string    `json:"fullName"`
+  Id        string    `json:"id"`
 }
 
 /* Check if a user is assigned to the card */
@@ -26,34 +26,122 @@ func (card *Card) loadMembers() {
 }
 
 /* Resolve user names to ids */
-func (trello *Trello) makeUserCache() {
+func (trello *Trello) makeUserCache() bool {
+  trello.loadUsers()
+  return true // same magic as with labels
+}
+
+func (trello *Trello) loadUsers() error {
   var members []tUser
-  GenGET(trello, "/boards/" + trello.BoardId + "/members/", &members)
+  if err := GenGET(trello, "/boards/" + trello.BoardId + "/members/", &members); err != nil {
+    return err
+  }
 
+  /* Trello doesn't tell board hooks about renames, this is where we notice them */
+  renamed := make(map[string]string)
   for _, v := range members {
+    if oldname := trello.userNamebyId[v.Id]; len(oldname) > 0 && oldname != v.Name {
+      delete(trello.userIdbyName, oldname)
+      delete(trello.userFullbyName, oldname)
+      renamed[oldname] = v.Name
+    }
     trello.userIdbyName[v.Name] = v.Id
+    trello.userFullbyName[v.Name] = v.FullName
   }
 
   /* Generating a reverse one too */
   trello.userNamebyId = DicRev(trello.userIdbyName)
+  for oldname, newname := range renamed {
+    Infof("User %s is now known as %s.", oldname, newname)
+    if trello.OnRenamed != nil {
+      trello.OnRenamed(oldname, newname)
+    }
+  }
+  return nil
+}
+
+/* Re-reads the board members, noticing renames */
+func (trello *Trello) RefreshMembers() error {
+  return trello.loadUsers()
 }
 
-/* Wrapper around the dictionary not to expose */
+/* Wrapper around the dictionary not to expose, refreshes it in case somebody new came by */
 func (trello *Trello) UserById(userid string) string {
-  return trello.userNamebyId[userid]
+  for updated := false; ; updated = trello.makeUserCache() {
+    if name := trello.userNamebyId[userid]; len(name) > 0 || updated || len(userid) == 0 {
+      return name
+    }
+  }
 }
 
 func (trello *Trello) UserByName(username string) string {
-  return trello.userIdbyName[username]
+  for updated := false; ; updated = trello.makeUserCache() {
+    if id := trello.userIdbyName[username]; len(id) > 0 || updated || len(username) == 0 {
+      return id
+    }
+  }
+}
+
+/* Usernames to ids of everybody on the board */
+func (trello *Trello) MemberIds() map[string]string {
+  return DicRev(trello.userNamebyId)
+}
+
+/* Board membership changes come from the hook */
+func (trello *Trello) AddMember(userid string, username string, fullname string) {
+  Infof("User %s joined the board.", username)
+  trello.userIdbyName[username] = userid
+  trello.userNamebyId[userid] = username
+  trello.userFullbyName[username] = fullname
+}
+
+/* Returns the name the user had */
+func (trello *Trello) RemoveMember(userid string) string {
+  username := trello.userNamebyId[userid]
+  Infof("User %s left the board.", username)
+  delete(trello.userNamebyId, userid)
+  delete(trello.userIdbyName, username)
+  delete(trello.userFullbyName, username)
+  return username
+}
+
+/* Cards the user is assigned to, as far as we know */
+func (trello *Trello) CardsWithMember(userid string) []*Card {
+  res := make([]*Card, 0)
+  for _, v := range trello.cardById {
+    if v.Members[userid] {
+      res = append(res, v)
+    }
+  }
+  return res
+}
+
+/* Full names of the board members by their usernames */
+func (trello *Trello) MemberNames() map[string]string {
+  res := make(map[string]string)
+  for k, v := range trello.userFullbyName {
+    res[k] = v
+  }
+  return res
 }
 
 /* Assign/Unassign a user to the card */
 func (card *Card) AddUser(user string) {
-  log.Printf("Adding user %s to card %s.", user, card.Id)
-  GenPOSTForm(card.trello, "/cards/" + card.Id + "/idMembers", nil, url.Values{ "value": { card.trello.userIdbyName[user] } })
+  userid := card.trello.UserByName(user)
+  if len(userid) == 0 {
+    Errorf("User %s is not on the board, can't add to card %s.", user, card.Id)
+    return
+  }
+  Infof("Adding user %s to card %s.", user, card.Id)
+  GenPOSTForm(card.trello, "/cards/" + card.Id + "/idMembers", nil, url.Values{ "value": { userid } })
 }
 
 func (card *Card) DelUser(user string) {
-  log.Printf("Removing user %s from card %s.", user, card.Id)
-  GenDEL(card.trello, "/cards/" + card.Id + "/idMembers/" + card.trello.userIdbyName[user])
+  userid := card.trello.UserByName(user)
+  if len(userid) == 0 {
+    Errorf("User %s is not on the board, can't remove from card %s.", user, card.Id)
+    return
+  }
+  Infof("Removing user %s from card %s.", user, card.Id)
+  GenDEL(card.trello, "/cards/" + card.Id + "/idMembers/" + userid)
 }
@@ -0,0 +1,194 @@
+package main
+
+import (
+  "encoding/json"
+  "net/http"
+  "strings"
+  "unicode"
+  . "github.com/ErintLabs/trellohub/genapi"
+)
+
+/* Trello to GitHub correspondence. $USER_TABLE is only the starting point,
+   once anything is changed at runtime the whole table lives in $USER_STORE */
+func loadUsers() {
+  json.Unmarshal([]byte(GetEnvOpt("USER_TABLE", "{}")), &cache.GitHubUserByTrello)
+  if len(config.UserStore) > 0 {
+    var stored map[string]string
+    if loadJSON(config.UserStore, &stored) {
+      Infof("Using the user table from %s.", config.UserStore)
+      cache.GitHubUserByTrello = stored
+    }
+  }
+
+  if cache.GitHubUserByTrello == nil {
+    cache.GitHubUserByTrello = make(map[string]string)
+  }
+  cache.TrelloUserByGitHub = DicRev(cache.GitHubUserByTrello)
+}
+
+/* Changes a single mapping, empty GitHub user removes it. Either user is only mapped once */
+func setUser(tuser string, guser string) {
+  if old := cache.GitHubUserByTrello[tuser]; len(old) > 0 {
+    delete(cache.TrelloUserByGitHub, old)
+  }
+  if old := cache.TrelloUserByGitHub[guser]; len(guser) > 0 && len(old) > 0 && old != tuser {
+    Infof("GitHub user %s is not Trello user %s anymore.", guser, old)
+    delete(cache.GitHubUserByTrello, old)
+  }
+
+  if len(guser) > 0 {
+    Infof("Mapping Trello user %s to GitHub user %s.", tuser, guser)
+    cache.GitHubUserByTrello[tuser] = guser
+    cache.TrelloUserByGitHub[guser] = tuser
+    delete(cache.UnmappedUsers, "GitHub:" + guser)
+  } else {
+    Infof("Forgetting Trello user %s.", tuser)
+    delete(cache.GitHubUserByTrello, tuser)
+  }
+  delete(cache.UnmappedUsers, "Trello:" + tuser)
+}
+
+/* The table is keyed by Trello names, so it follows renames */
+func renameUser(oldname string, newname string) {
+  if guser := cache.GitHubUserByTrello[oldname]; len(guser) > 0 {
+    setUser(oldname, "")
+    setUser(newname, guser)
+    saveUsers()
+  }
+}
+
+func saveUsers() {
+  if len(config.UserStore) > 0 {
+    if err := saveJSON(config.UserStore, cache.GitHubUserByTrello); err != nil {
+      Errorf("Can't save the user table: %s", err)
+    }
+  }
+}
+
+/* Lowercase letters and digits only, so that "Jane Doe", "jane_doe" and "janedoe" are the same */
+func normaliseName(name string) string {
+  return strings.Map(func (r rune) rune {
+    if unicode.IsLetter(r) || unicode.IsDigit(r) {
+      return unicode.ToLower(r)
+    }
+    return -1
+  }, name)
+}
+
+/* Matches unmapped board members against collaborators of our repositories by usernames and full names.
+   Only unambiguous pairs are proposed */
+func proposeUsers() map[string]string {
+  collaborators := make(map[string]string)
+  for _, repo := range trello_obj.Repos() {
+    users, err := github_obj.Collaborators(repo)
+    if err != nil {
+      Warnf("Can't list all the collaborators of %s: %s", repo, err)
+    }
+    for _, v := range users {
+      if len(cache.TrelloUserByGitHub[v.Login]) == 0 {
+        collaborators[v.Login] = v.Name
+      }
+    }
+  }
+
+  res := make(map[string]string)
+  claimed := make(map[string]int)
+  for tuser, tfull := range trello_obj.MemberNames() {
+    if len(cache.GitHubUserByTrello[tuser]) > 0 {
+      continue
+    }
+
+    tnames := []string{ normaliseName(tuser), normaliseName(tfull) }
+    candidates := make([]string, 0)
+    for guser, gfull := range collaborators {
+      gnames := []string{ normaliseName(guser), normaliseName(gfull) }
+      if namesMatch(tnames, gnames) {
+        candidates = append(candidates, guser)
+      }
+    }
+
+    if len(candidates) == 1 {
+      res[tuser] = candidates[0]
+      claimed[candidates[0]]++
+    }
+  }
+
+  /* Nor should a GitHub user be proposed twice */
+  for tuser, guser := range res {
+    if claimed[guser] > 1 {
+      delete(res, tuser)
+    }
+  }
+
+  return res
+}
+
+func namesMatch(a []string, b []string) bool {
+  for _, x := range a {
+    for _, y := range b {
+      if len(x) > 0 && x == y {
+        return true
+      }
+    }
+  }
+  return false
+}
+
+/* GET lists the table and users we've seen but can't map, POST merges a {"trello": "github"} object
+   into the table (empty value removes), DELETE ?trello=name removes one */
+func UsersFunc(w http.ResponseWriter, r *http.Request) {
+  AdminProcess(w, r, func (r *http.Request, body []byte) (int, interface{}) {
+    switch r.Method {
+    case "GET":
+      unmapped := make([]string, 0, len(cache.UnmappedUsers))
+      for k := range cache.UnmappedUsers {
+        unmapped = append(unmapped, k)
+      }
+      return http.StatusOK, struct {
+        Users     map[string]string `json:"users"`
+        Unmapped  []string          `json:"unmapped"`
+      }{ cache.GitHubUserByTrello, unmapped }
+
+    case "POST", "PUT":
+      var table map[string]string
+      if err := json.Unmarshal(body, &table); err != nil {
+        return adminError(http.StatusBadRequest, "Expected a {\"trello\": \"github\"} object: " + err.Error())
+      }
+      for k, v := range table {
+        setUser(k, v)
+      }
+      saveUsers()
+      return http.StatusOK, cache.GitHubUserByTrello
+
+    case "DELETE":
+      tuser := r.URL.Query().Get("trello")
+      if len(cache.GitHubUserByTrello[tuser]) == 0 {
+        return adminError(http.StatusNotFound, "No such user in the table.")
+      }
+      setUser(tuser, "")
+      saveUsers()
+      return http.StatusOK, cache.GitHubUserByTrello
+    }
+
+    return adminError(http.StatusMethodNotAllowed, "GET, POST or DELETE please.")
+  })
+}
+
+/* GET proposes mappings for users not in the table, POST confirms all of them */
+func UserProposalsFunc(w http.ResponseWriter, r *http.Request) {
+  AdminProcess(w, r, func (r *http.Request, body []byte) (int, interface{}) {
+    proposals := proposeUsers()
+    switch r.Method {
+    case "GET":
+      return http.StatusOK, proposals
+    case "POST":
+      for k, v := range proposals {
+        setUser(k, v)
+      }
+      saveUsers()
+      return http.StatusOK, cache.GitHubUserByTrello
+    }
+
+    return adminError(http.StatusMethodNotAllowed, "GET or POST please.")
+  })
+}